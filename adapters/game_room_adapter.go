@@ -2,68 +2,67 @@ package adapters
 
 import (
 	"log"
+	"strconv"
+	"time"
 
 	"github.com/gofiber/websocket/v2"
 	"github.com/nuttaponsrpn/go-splendor/core"
+	"github.com/nuttaponsrpn/go-splendor/gotype"
 )
 
 type GameRoomAdapter struct {
-	gr core.GameRoom
+	gr    core.GameRoom
+	lobby *lobbyHub
 }
 
 func NewGameRoomAdapter(gr *core.GameRoom) *GameRoomAdapter {
 	return &GameRoomAdapter{
-		gr: *gr,
+		gr:    *gr,
+		lobby: newLobbyHub(*gr),
 	}
 }
 
 func (roomAdapter *GameRoomAdapter) HandleConnections(conn *websocket.Conn) {
 	roomID := conn.Query("room_id")
 	playerID := conn.Query("player_id")
-	if roomID == "" {
+	if roomID == "" || playerID == "" {
 		conn.Close()
 		return
 	}
 
-	roomAdapter.gr.CreateRoom(roomID, playerID, conn)
-}
+	nobleChoiceSeconds, _ := strconv.Atoi(conn.Query("noble_timeout"))
+	seed, _ := strconv.ParseInt(conn.Query("seed"), 10, 64)
+	minPlayers, _ := strconv.Atoi(conn.Query("min_players"))
+	maxPlayers, _ := strconv.Atoi(conn.Query("max_players"))
+	reconnectSeconds, _ := strconv.Atoi(conn.Query("reconnect_timeout"))
+	settings := gotype.GameSettings{
+		Variant:            gotype.SetupVariant(conn.Query("variant", string(gotype.StandardSetup))),
+		NobleChoiceSeconds: nobleChoiceSeconds,
+		Seed:               seed,
+		MinPlayers:         minPlayers,
+		MaxPlayers:         maxPlayers,
+		ReconnectSeconds:   reconnectSeconds,
+	}
 
-var roomClients = make(map[*websocket.Conn]bool)
+	roomAdapter.gr.JoinRoom(roomID, playerID, conn.Query("session"), settings, conn)
+}
 
+// ShowPlayerRooms sends the room list and then every lobby event to conn until
+// it disconnects or its subscription is dropped for falling behind.
 func (roomAdapter *GameRoomAdapter) ShowPlayerRooms(conn *websocket.Conn) {
-	defer func() {
-		conn.Close()
-		delete(roomClients, conn)
-	}()
-
-	_, exists := roomClients[conn]
-	if exists {
-		for clients := range roomClients {
-			clients.WriteJSON(roomAdapter.gr.GetRoom())
-		}
-	} else {
-		roomClients[conn] = true
-		for clients := range roomClients {
-			clients.WriteJSON(roomAdapter.gr.GetRoom())
-		}
-	}
+	sub := roomAdapter.lobby.register(conn)
 
+	done := make(chan struct{})
 	go func() {
-		for {
-			room := <-roomAdapter.gr.GetRoomChannel()
-			_, exists := roomClients[conn]
-			if exists && room != "" {
-				for clients := range roomClients {
-					clients.WriteJSON(roomAdapter.gr.GetRoom())
-				}
-			} else {
-				roomClients[conn] = true
-				for clients := range roomClients {
-					clients.WriteJSON(roomAdapter.gr.GetRoom())
-				}
-			}
+		defer close(done)
+		roomAdapter.lobby.serve(conn, sub)
+		// Wake the read loop below, closing a hijacked connection only takes effect once the handler returns
+		conn.SetReadDeadline(time.Now())
+	}()
 
-		}
+	defer func() {
+		roomAdapter.lobby.unregister(conn)
+		<-done
 	}()
 
 	for {
@@ -74,7 +73,6 @@ func (roomAdapter *GameRoomAdapter) ShowPlayerRooms(conn *websocket.Conn) {
 			break
 		}
 		if len(msg) == 1 && msg[0].RoomID == "close" {
-			conn.Close()
 			return
 		}
 	}
@@ -0,0 +1,71 @@
+package adapters
+
+import (
+	"log"
+	"sync"
+
+	"github.com/gofiber/websocket/v2"
+	"github.com/nuttaponsrpn/go-splendor/core"
+)
+
+const lobbySnapshot = "snapshot"
+
+type LobbySnapshot struct {
+	Type  string              `json:"type"`
+	Rooms []core.DisplayRooms `json:"rooms"`
+}
+
+// lobbyHub tracks the connections watching the room list. Every subscriber is
+// sent a snapshot of GetRoom when it registers and the lobby events after that.
+type lobbyHub struct {
+	gr          core.GameRoom
+	mu          sync.Mutex
+	subscribers map[*websocket.Conn]*core.LobbySubscriber
+}
+
+func newLobbyHub(gr core.GameRoom) *lobbyHub {
+	return &lobbyHub{
+		gr:          gr,
+		subscribers: make(map[*websocket.Conn]*core.LobbySubscriber),
+	}
+}
+
+func (h *lobbyHub) register(conn *websocket.Conn) *core.LobbySubscriber {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := h.gr.Lobby().Subscribe()
+	h.subscribers[conn] = sub
+	return sub
+}
+
+func (h *lobbyHub) unregister(conn *websocket.Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if sub, ok := h.subscribers[conn]; ok {
+		h.gr.Lobby().Unsubscribe(sub)
+		delete(h.subscribers, conn)
+	}
+}
+
+// serve streams the room list to conn until the subscription ends. It is the
+// only writer of conn, which websocket connections require.
+func (h *lobbyHub) serve(conn *websocket.Conn, sub *core.LobbySubscriber) {
+	// Subscribing before the snapshot means no event is missed in between
+	rooms := h.gr.GetRoom()
+	if rooms == nil {
+		rooms = []core.DisplayRooms{}
+	}
+	if err := conn.WriteJSON(LobbySnapshot{Type: lobbySnapshot, Rooms: rooms}); err != nil {
+		log.Printf("error: %v", err)
+		return
+	}
+
+	for event := range sub.Events() {
+		if err := conn.WriteJSON(event); err != nil {
+			log.Printf("error: %v", err)
+			return
+		}
+	}
+}
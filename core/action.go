@@ -0,0 +1,115 @@
+package core
+
+import (
+	"encoding/json"
+
+	"github.com/nuttaponsrpn/go-splendor/gotype"
+)
+
+type ActionType string
+
+const (
+	JoinAction        ActionType = "join"
+	LeaveAction       ActionType = "leave"
+	ReadyAction       ActionType = "ready"
+	StartGameAction   ActionType = "start_game"
+	TakeGemsAction    ActionType = "take_gems"
+	PurchaseAction    ActionType = "purchase"
+	ReserveAction     ActionType = "reserve"
+	DiscardAction     ActionType = "discard"
+	ChooseNobleAction ActionType = "choose_noble"
+)
+
+// ActionMessage is the envelope of every message a client sends to its room.
+// Type selects which payload struct Payload is decoded into, and the optional
+// Id is echoed back on any error caused by the action.
+type ActionMessage struct {
+	Id       string          `json:"id"`
+	Type     ActionType      `json:"type"`
+	PlayerId string          `json:"playerId"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+type ReadyPayload struct {
+	Ready bool `json:"ready"`
+}
+
+type TakeGemsPayload struct {
+	Gems []gotype.GemType `json:"gems"`
+}
+
+// PurchasePayload names a card by Level and CardId together, card ids are only
+// unique within a level.
+type PurchasePayload struct {
+	CardId int `json:"cardId"`
+	Level  int `json:"level"`
+}
+
+// ReservePayload reserves the face-up card CardId of Level, or the top card of
+// the Level draw pile when CardId is empty.
+type ReservePayload struct {
+	CardId int `json:"cardId"`
+	Level  int `json:"level"`
+}
+
+type DiscardPayload struct {
+	Gems []gotype.GemType `json:"gems"`
+}
+
+type ChooseNoblePayload struct {
+	NobleId int `json:"nobleId"`
+}
+
+func DecodePayload[T any](msg ActionMessage) (T, error) {
+	var payload T
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return payload, NewGameError(CodeInvalidAction, "invalid "+string(msg.Type)+" payload")
+	}
+	return payload, nil
+}
+
+// DispatchGameAction decodes the payload of a game action and applies it to the
+// game. Join and leave change the room itself and are handled by the room.
+func DispatchGameAction(gameService GameService, msg ActionMessage) error {
+	switch msg.Type {
+	case ReadyAction:
+		payload, err := DecodePayload[ReadyPayload](msg)
+		if err != nil {
+			return err
+		}
+		return gameService.SetReady(msg.PlayerId, payload.Ready)
+	case StartGameAction:
+		return gameService.StartGame(msg.PlayerId)
+	case TakeGemsAction:
+		payload, err := DecodePayload[TakeGemsPayload](msg)
+		if err != nil {
+			return err
+		}
+		return gameService.TakeGems(msg.PlayerId, payload.Gems)
+	case PurchaseAction:
+		payload, err := DecodePayload[PurchasePayload](msg)
+		if err != nil {
+			return err
+		}
+		return gameService.PurchaseCard(msg.PlayerId, payload.CardId, payload.Level)
+	case ReserveAction:
+		payload, err := DecodePayload[ReservePayload](msg)
+		if err != nil {
+			return err
+		}
+		return gameService.ReserveCard(msg.PlayerId, payload.CardId, payload.Level)
+	case DiscardAction:
+		payload, err := DecodePayload[DiscardPayload](msg)
+		if err != nil {
+			return err
+		}
+		return gameService.DiscardGems(msg.PlayerId, payload.Gems)
+	case ChooseNobleAction:
+		payload, err := DecodePayload[ChooseNoblePayload](msg)
+		if err != nil {
+			return err
+		}
+		return gameService.ChooseNoble(msg.PlayerId, payload.NobleId)
+	}
+	return NewGameError(CodeInvalidAction, "unknown action type: "+string(msg.Type))
+}
@@ -0,0 +1,32 @@
+package core
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/nuttaponsrpn/go-splendor/gotype"
+)
+
+// Deck is one game's own copy of the card catalog in init_value.go. Games
+// shuffle and deal from their deck so rooms never share backing arrays.
+type Deck struct {
+	Level1 []gotype.DevelopmentCard
+	Level2 []gotype.DevelopmentCard
+	Level3 []gotype.DevelopmentCard
+	Nobles []gotype.NobleCard
+}
+
+func NewDeck() Deck {
+	nobles := make([]gotype.NobleCard, len(Nobles))
+	for index, noble := range Nobles {
+		noble.Cost = maps.Clone(noble.Cost)
+		nobles[index] = noble
+	}
+
+	return Deck{
+		Level1: slices.Clone(DevelopmentLevel1),
+		Level2: slices.Clone(DevelopmentLevel2),
+		Level3: slices.Clone(DevelopmentLevel3),
+		Nobles: nobles,
+	}
+}
@@ -0,0 +1,43 @@
+package core
+
+type GameErrorCode string
+
+const (
+	CodeInvalidGemSelection GameErrorCode = "invalid_gem_selection"
+	CodeMustDiscard         GameErrorCode = "must_discard"
+	CodeInvalidDiscard      GameErrorCode = "invalid_discard"
+	CodeCardNotFound        GameErrorCode = "card_not_found"
+	CodeCannotAfford        GameErrorCode = "cannot_afford"
+	CodeReserveLimit        GameErrorCode = "reserve_limit"
+	CodeDeckEmpty           GameErrorCode = "deck_empty"
+	CodeGameEnded           GameErrorCode = "game_ended"
+	CodeGameStarted         GameErrorCode = "game_started"
+	CodeGameNotStarted      GameErrorCode = "game_not_started"
+	CodeInvalidPlayerCount  GameErrorCode = "invalid_player_count"
+	CodeRoomFull            GameErrorCode = "room_full"
+	CodeNotHost             GameErrorCode = "not_host"
+	CodePlayersNotReady     GameErrorCode = "players_not_ready"
+	CodeGamePaused          GameErrorCode = "game_paused"
+	CodeSeatTaken           GameErrorCode = "seat_taken"
+	CodeInvalidSession      GameErrorCode = "invalid_session"
+	CodeMustChooseNoble     GameErrorCode = "must_choose_noble"
+	CodeInvalidNoble        GameErrorCode = "invalid_noble"
+	CodeNotYourTurn         GameErrorCode = "not_your_turn"
+	CodeInvalidAction       GameErrorCode = "invalid_action"
+	CodeWrongPlayer         GameErrorCode = "wrong_player"
+	CodePlayerNotFound      GameErrorCode = "player_not_found"
+	CodeInternal            GameErrorCode = "internal"
+)
+
+type GameError struct {
+	Code    GameErrorCode `json:"code"`
+	Message string        `json:"message"`
+}
+
+func NewGameError(code GameErrorCode, message string) *GameError {
+	return &GameError{Code: code, Message: message}
+}
+
+func (e *GameError) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
@@ -2,164 +2,492 @@ package core
 
 import (
 	"log"
+	"math/rand"
+	"time"
 
 	"github.com/gofiber/websocket/v2"
 	"github.com/nuttaponsrpn/go-splendor/gotype"
 )
 
 type Client struct {
-	conn *websocket.Conn
-	Room *Room `json:"room"`
+	conn     *websocket.Conn
+	playerID string
+	Room     *Room `json:"room"`
 }
 
+type RoomState string
+
+const (
+	// RoomCreated rooms exist but nobody has joined them yet
+	RoomCreated RoomState = "created"
+	RoomOpen    RoomState = "open"
+	// RoomClosed rooms have stopped their run loop and left the registry
+	RoomClosed RoomState = "closed"
+)
+
+// RoomIdleTimeout is how long a room may wait for its first player before it
+// closes, so rooms created over HTTP and never joined do not hold their id.
+const RoomIdleTimeout = 5 * time.Minute
+
+// RoomTimer is a pending call started by AfterFunc.
+type RoomTimer interface {
+	Stop() bool
+}
+
+// AfterFunc starts the room timers. It is time.AfterFunc outside of tests.
+type AfterFunc func(d time.Duration, f func()) RoomTimer
+
+func realAfterFunc(d time.Duration, f func()) RoomTimer {
+	return time.AfterFunc(d, f)
+}
+
+// Room owns one game. Its run loop is the only goroutine that touches the game
+// state, the clients map or the client connections; everyone else hands it
+// work through Do.
 type Room struct {
+	id          string
+	rooms       *RoomRegistry
+	lobby       *LobbyHub
+	state       RoomState
 	clients     map[*Client]string
-	register    chan *Client
-	unregister  chan *Client
-	broadcast   chan gotype.GameState
-	close       chan bool
-	GameService GameService `json:"gameService"`
+	sessions    map[string]*Session
+	nobleTimer  RoomTimer
+	idleTimer   RoomTimer
+	afterFunc   AfterFunc
+	commands    chan func()
+	closed      chan struct{}
+	GameService GameService
 }
 
 type GameRoom interface {
-	CreateRoom(roomID string, playerID string, conn *websocket.Conn)
-	DeleteRoom(roomID string) *Room
+	CreateRoom(roomID string, creatorID string, settings gotype.GameSettings) (*Room, bool)
+	JoinRoom(roomID string, playerID string, sessionToken string, settings gotype.GameSettings, conn *websocket.Conn)
+	CloseRoom(roomID string) *Room
 	GetRoom() []DisplayRooms
-	GetRoomChannel() chan string
+	Lobby() *LobbyHub
 }
 
 type GameRoomService struct {
-	rooms       map[string]*Room
-	roomChannel chan string
+	rooms     *RoomRegistry
+	lobby     *LobbyHub
+	afterFunc AfterFunc
 }
 
-func NewGameRoomService(rooms *map[string]*Room) GameRoom {
+func NewGameRoomService(rooms *RoomRegistry) GameRoom {
 	return &GameRoomService{
-		rooms:       *rooms,
-		roomChannel: make(chan string),
+		rooms:     rooms,
+		lobby:     NewLobbyHub(),
+		afterFunc: realAfterFunc,
 	}
 }
 
-func (gs *GameRoomService) CreateRoom(roomID string, playerID string, conn *websocket.Conn) {
-	room, exists := gs.rooms[roomID]
-	if !exists {
-		room := &Room{
+// CreateRoom registers and starts a room created by creatorID, who hosts it
+// once seated, or returns the open room already using roomID. The bool reports
+// whether the room was created.
+func (gs *GameRoomService) CreateRoom(roomID string, creatorID string, settings gotype.GameSettings) (*Room, bool) {
+	// Rooms created without a seed still get one so the game can be replayed
+	if settings.Seed == 0 {
+		settings.Seed = rand.Int63()
+	}
+	settings.MinPlayers, settings.MaxPlayers = PlayerLimits(settings)
+
+	room, created := gs.rooms.GetOrCreate(roomID, func() *Room {
+		return &Room{
+			id:          roomID,
+			rooms:       gs.rooms,
+			lobby:       gs.lobby,
+			state:       RoomCreated,
 			clients:     make(map[*Client]string),
-			register:    make(chan *Client),
-			unregister:  make(chan *Client),
-			broadcast:   make(chan gotype.GameState),
-			GameService: NewGameService(gotype.GameState{}),
+			sessions:    make(map[string]*Session),
+			afterFunc:   gs.afterFunc,
+			commands:    make(chan func()),
+			closed:      make(chan struct{}),
+			GameService: NewGameService(gotype.GameState{State: gotype.Waiting, Settings: settings, CreatorId: creatorID}),
 		}
+	})
+	if created {
 		// Detect message from other client
-		go room.run(roomID, playerID, gs.rooms)
-		// Add room id to rooms pool
-		gs.rooms[roomID] = room
+		go room.run()
+		room.Do(room.closeIfIdle)
+		gs.lobby.Publish(LobbyEvent{
+			Type:         RoomCreatedEvent,
+			DisplayRooms: DisplayRooms{RoomID: roomID, MaxPlayers: settings.MaxPlayers},
+		})
 	}
+	return room, created
+}
 
-	// Send client to register in room channle
-	client := &Client{conn: conn, Room: room}
-	gs.rooms[roomID].register <- client
-
-	defer func() {
-		room, exists := gs.rooms[roomID]
-		if exists {
-			_, exists := room.clients[client]
-			if exists {
-				room.unregister <- client
-				gs.roomChannel <- roomID
+// JoinRoom attaches the connection to the room, creating the room on first
+// join, and serves the client's messages until it disconnects. A session token
+// reconnects the player to the seat it was issued for.
+func (gs *GameRoomService) JoinRoom(roomID string, playerID string, sessionToken string, settings gotype.GameSettings, conn *websocket.Conn) {
+	client := &Client{conn: conn, playerID: playerID}
+	if sessionToken != "" {
+		if room, exists := gs.rooms.Get(roomID); exists {
+			room.Do(func() {
+				if room.resume(client, sessionToken) {
+					room.broadcastState()
+				}
+			})
+		}
+		if client.Room == nil {
+			err := NewGameError(CodeInvalidSession, "session has expired or does not belong to player "+playerID)
+			if err := conn.WriteJSON(NewErrorMessage(err, "")); err != nil {
+				log.Printf("error: %v", err)
 			}
 			conn.Close()
+			return
 		}
+	}
+
+	for client.Room == nil {
+		room, _ := gs.CreateRoom(roomID, playerID, settings)
+		// The room may close between the lookup and the join, then a new one is created
+		room.Do(func() {
+			if room.join(client) {
+				client.Room = room
+			}
+		})
+	}
+	room := client.Room
+
+	defer func() {
+		room.Do(func() {
+			if room.leave(client) {
+				room.broadcastState()
+			}
+		})
+		conn.Close()
 	}()
 
 	for {
-		var msg WebsocketPlayerAction
+		var msg ActionMessage
 		err := conn.ReadJSON(&msg)
 		if err != nil {
 			log.Printf("error: %v", err)
 			break
 		}
 
-		gameState := gs.rooms[roomID].GameService.GetGameState()
-
-		switch msg.Status {
-		case gotype.Waiting:
-			gs.rooms[roomID].GameService.JoinPlayer(msg.PlayerId)
-			gameState = gs.rooms[roomID].GameService.GetGameState()
-			gs.roomChannel <- roomID
-		case gotype.Started:
-			gs.rooms[roomID].GameService.UpdateGameState(msg)
-			gameState = gs.rooms[roomID].GameService.GetGameState()
-		case gotype.CloseConnection:
-			gs.rooms[roomID].GameService.RemovePlayer(msg.PlayerId)
-			gameState = gs.rooms[roomID].GameService.GetGameState()
-			gs.rooms[roomID].unregister <- client
-			gs.roomChannel <- roomID
+		if !room.Do(func() { room.handleAction(client, msg) }) {
+			break
 		}
+	}
+}
 
-		if gs.rooms[roomID] != nil {
-			gs.rooms[roomID].broadcast <- gameState
-		}
+// disconnect ends the client's connection. Closing a hijacked connection only
+// takes effect once its handler returns, so the close frame and the expired
+// read deadline are what wake the handler's read loop.
+func (c *Client) disconnect() {
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	if err := c.conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second)); err != nil {
+		log.Printf("error: %v", err)
 	}
+	c.conn.SetReadDeadline(time.Now())
+	c.conn.Close()
 }
 
-func (r *Room) run(roomID string, playerID string, rooms map[string]*Room) {
-	for {
-		select {
-		case client := <-r.register:
-			r.clients[client] = playerID
-		case client := <-r.unregister:
-			if _, ok := r.clients[client]; ok {
-				delete(rooms[roomID].clients, client)
-				client.conn.Close()
-				if len(r.clients) == 0 {
-					delete(rooms, roomID)
-				}
-			}
-		case message := <-r.broadcast:
-			for client := range r.clients {
-				err := client.conn.WriteJSON(message)
-				if err != nil {
-					log.Printf("error: %v", err)
-					client.conn.Close()
-					delete(r.clients, client)
-				}
+func (r *Room) run() {
+	defer close(r.closed)
+
+	for r.state != RoomClosed {
+		command := <-r.commands
+		command()
+	}
+}
+
+// Do runs fn on the room's goroutine and waits for it to finish. It reports
+// false without running fn once the room has closed.
+func (r *Room) Do(fn func()) bool {
+	done := make(chan struct{})
+	select {
+	case r.commands <- func() {
+		fn()
+		close(done)
+	}:
+		<-done
+		return true
+	case <-r.closed:
+		return false
+	}
+}
+
+func (r *Room) join(client *Client) bool {
+	if r.state == RoomClosed {
+		return false
+	}
+
+	r.clients[client] = client.playerID
+	r.state = RoomOpen
+	if r.idleTimer != nil {
+		r.idleTimer.Stop()
+		r.idleTimer = nil
+	}
+	return true
+}
+
+// closeIfIdle closes the room once RoomIdleTimeout passes without anyone
+// joining it.
+func (r *Room) closeIfIdle() {
+	// A player may have joined before the timer was armed
+	if r.state != RoomCreated {
+		return
+	}
+	r.idleTimer = r.afterFunc(RoomIdleTimeout, func() {
+		r.Do(func() {
+			if r.state == RoomCreated {
+				r.shutdown()
 			}
-		case <-r.close:
-			for client := range r.clients {
-				client.conn.Close()
-				delete(r.clients, client)
+		})
+	})
+}
+
+// leave drops the client. A seated player keeps their seat for the grace
+// period, so the room only closes once its last client and seat have gone.
+func (r *Room) leave(client *Client) bool {
+	if _, ok := r.clients[client]; !ok {
+		return false
+	}
+
+	delete(r.clients, client)
+	client.disconnect()
+	if session, ok := r.sessions[client.playerID]; ok && session.client == client {
+		r.disconnect(session)
+	}
+	r.closeIfAbandoned()
+	return true
+}
+
+func (r *Room) closeIfAbandoned() {
+	// A failed write may already have closed the room while broadcasting
+	if r.state == RoomClosed {
+		return
+	}
+	if len(r.clients) == 0 && len(r.sessions) == 0 {
+		r.shutdown()
+	} else {
+		r.publish(PlayersChangedEvent)
+	}
+}
+
+// shutdown disconnects every client and unregisters the room. It runs on the
+// room's goroutine, which stops once the current command returns, and does
+// nothing on a room that has already closed.
+func (r *Room) shutdown() {
+	if r.state == RoomClosed {
+		return
+	}
+	for client := range r.clients {
+		client.disconnect()
+		delete(r.clients, client)
+	}
+	for playerID, session := range r.sessions {
+		if session.timer != nil {
+			session.timer.Stop()
+		}
+		delete(r.sessions, playerID)
+	}
+	r.stopNobleAutoPick()
+	if r.idleTimer != nil {
+		r.idleTimer.Stop()
+		r.idleTimer = nil
+	}
+	r.rooms.Remove(r.id, r)
+	r.state = RoomClosed
+	r.publish(RoomClosedEvent)
+}
+
+func (r *Room) publish(eventType LobbyEventType) {
+	r.lobby.Publish(LobbyEvent{Type: eventType, DisplayRooms: r.display()})
+}
+
+func (r *Room) display() DisplayRooms {
+	gameState := r.GameService.GetGameState()
+	return DisplayRooms{
+		RoomID:     r.id,
+		Players:    len(gameState.Players),
+		MaxPlayers: gameState.Settings.MaxPlayers,
+		Started:    gameState.State != gotype.Waiting,
+	}
+}
+
+func (r *Room) Close() {
+	r.Do(r.shutdown)
+}
+
+func (r *Room) handleAction(client *Client, msg ActionMessage) {
+	// A connection may only act for the player it joined as
+	if msg.PlayerId != client.playerID {
+		err := NewGameError(CodeWrongPlayer, "connection does not belong to player "+msg.PlayerId)
+		r.sendTo(client, NewErrorMessage(err, msg.Id))
+		return
+	}
+
+	switch msg.Type {
+	case JoinAction:
+		if _, ok := r.sessions[msg.PlayerId]; ok && !r.holdsSeat(client) {
+			err := NewGameError(CodeSeatTaken, "player "+msg.PlayerId+" is already seated, reconnect with its session")
+			r.sendTo(client, NewErrorMessage(err, msg.Id))
+			return
+		}
+		if err := r.GameService.JoinPlayer(msg.PlayerId); err != nil {
+			r.sendTo(client, NewErrorMessage(err, msg.Id))
+			return
+		}
+		if err := r.seat(client); err != nil {
+			r.sendTo(client, NewErrorMessage(err, msg.Id))
+			return
+		}
+		r.publish(PlayersChangedEvent)
+	case LeaveAction:
+		if r.holdsSeat(client) {
+			delete(r.sessions, msg.PlayerId)
+			if err := r.GameService.RemovePlayer(msg.PlayerId); err != nil {
+				log.Printf("error: %v", err)
 			}
-			delete(rooms, roomID)
+			r.updatePaused()
+			r.announceEvents()
+		}
+		r.leave(client)
+		if r.state == RoomClosed {
+			return
+		}
+	default:
+		// Only the connection holding the seat plays for it
+		if !r.holdsSeat(client) {
+			err := NewGameError(CodeWrongPlayer, "connection does not hold the seat of player "+msg.PlayerId)
+			r.sendTo(client, NewErrorMessage(err, msg.Id))
+			return
+		}
+		if err := ValidateLegalAction(r.GameService.GetGameState(), msg); err != nil {
+			r.sendTo(client, NewErrorMessage(err, msg.Id))
+			return
+		}
+		if err := DispatchGameAction(r.GameService, msg); err != nil {
+			// Rejected actions leave the game untouched, only the sender is told why
+			r.sendTo(client, NewErrorMessage(err, msg.Id))
 			return
 		}
+		if msg.Type == StartGameAction {
+			r.publish(GameStartedEvent)
+		}
+		// Players may have dropped while the room was waiting to start
+		r.updatePaused()
+		r.announceEvents()
+		if r.GameService.GetGameState().Phase == gotype.NoblePhase {
+			r.scheduleNobleAutoPick()
+		}
 	}
+
+	r.broadcastState()
 }
 
-func (gs *GameRoomService) DeleteRoom(roomID string) *Room {
-	clientsLen := gs.rooms[roomID].clients
+func (r *Room) broadcastState() {
+	gameState := r.GameService.GetGameState()
+	for client, playerID := range r.clients {
+		// Connections that do not hold their player's seat only see what spectators see
+		if !r.holdsSeat(client) {
+			playerID = ""
+		}
+		view := ViewForPlayer(gameState, playerID)
+		var legalActions []LegalAction
+		if !gameState.Paused && playerID != "" {
+			legalActions = LegalActions(gameState, playerID)
+		}
+		err := client.conn.WriteJSON(ServerMessage{
+			Type:         StateMessage,
+			State:        &view,
+			LegalActions: legalActions,
+		})
+		if err != nil {
+			log.Printf("error: %v", err)
+			r.leave(client)
+		}
+	}
+}
+
+func (r *Room) announceEvents() {
+	for _, event := range r.GameService.DrainEvents() {
+		for client := range r.clients {
+			if err := client.conn.WriteJSON(ServerMessage{Type: EventMessage, Event: &event}); err != nil {
+				log.Printf("error: %v", err)
+			}
+		}
+	}
+}
 
-	for client := range clientsLen {
-		gs.rooms[roomID].unregister <- client
-		gs.roomChannel <- roomID
+func (r *Room) sendTo(client *Client, message ServerMessage) {
+	if _, ok := r.clients[client]; !ok {
+		return
 	}
+	if err := client.conn.WriteJSON(message); err != nil {
+		log.Printf("error: %v", err)
+	}
+}
+
+// scheduleNobleAutoPick picks a noble for the current player if they have not
+// chosen one before the room's timeout. It replaces any pick already pending.
+func (r *Room) scheduleNobleAutoPick() {
+	r.stopNobleAutoPick()
+
+	gameState := r.GameService.GetGameState()
+	turn := gameState.Turn
+	var timer RoomTimer
+	timer = r.afterFunc(NobleChoiceTimeout(gameState.Settings), func() {
+		r.Do(func() {
+			// A timer stopped after it fired still reaches the room, only the pending one may pick
+			if r.nobleTimer != timer {
+				return
+			}
+			r.nobleTimer = nil
+			if err := r.GameService.AutoChooseNoble(turn); err != nil {
+				return
+			}
+			r.announceEvents()
+			r.broadcastState()
+		})
+	})
+	r.nobleTimer = timer
+}
+
+func (r *Room) stopNobleAutoPick() {
+	if r.nobleTimer != nil {
+		r.nobleTimer.Stop()
+		r.nobleTimer = nil
+	}
+}
+
+func (gs *GameRoomService) CloseRoom(roomID string) *Room {
+	room, exists := gs.rooms.Get(roomID)
+	if !exists {
+		return nil
+	}
+
+	room.Close()
 
-	return gs.rooms[roomID]
+	return room
 }
 
 type DisplayRooms struct {
-	RoomID  string `json:"roomID"`
-	Players int    `json:"players"`
+	RoomID     string `json:"roomID"`
+	Players    int    `json:"players"`
+	MaxPlayers int    `json:"maxPlayers"`
+	Started    bool   `json:"started"`
 }
 
+// GetRoom lists every open room, full and started ones included, so the
+// lobby can show them with their capacity.
 func (gs *GameRoomService) GetRoom() []DisplayRooms {
 	var availableRoom []DisplayRooms
 
-	if len(gs.rooms) > 0 {
-		for roomID := range gs.rooms {
-			playerLength := len(gs.rooms[roomID].GameService.GetGameState().Players)
-			if playerLength < 3 {
-				availableRoom = append(availableRoom, DisplayRooms{RoomID: roomID, Players: playerLength})
+	rooms := gs.rooms.Snapshot()
+	if len(rooms) > 0 {
+		for _, room := range rooms {
+			var display DisplayRooms
+			isOpen := room.Do(func() {
+				display = room.display()
+			})
+			if isOpen {
+				availableRoom = append(availableRoom, display)
 			}
 		}
 	}
@@ -167,6 +495,6 @@ func (gs *GameRoomService) GetRoom() []DisplayRooms {
 	return availableRoom
 }
 
-func (gs *GameRoomService) GetRoomChannel() chan string {
-	return gs.roomChannel
+func (gs *GameRoomService) Lobby() *LobbyHub {
+	return gs.lobby
 }
@@ -0,0 +1,496 @@
+package core
+
+import (
+	"encoding/json"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	fastws "github.com/fasthttp/websocket"
+	"github.com/nuttaponsrpn/go-splendor/gotype"
+)
+
+// roomClients lists the room of every client connected to the room.
+func roomClients(room *Room) []*Room {
+	var clientRooms []*Room
+	room.Do(func() {
+		for client := range room.clients {
+			clientRooms = append(clientRooms, client.Room)
+		}
+	})
+	return clientRooms
+}
+
+func TestJoinRoomCreatorAndJoinersShareTheRoom(t *testing.T) {
+	rooms := NewRoomRegistry()
+	_, serverURL := newTestServer(t, rooms)
+
+	creator, err := dialRoom(serverURL, "r1", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer creator.Close()
+
+	var room *Room
+	waitFor(t, "the creator to join", func() bool {
+		room, _ = rooms.Get("r1")
+		return room != nil && len(roomClients(room)) == 1
+	})
+	if clientRooms := roomClients(room); clientRooms[0] != room {
+		t.Fatalf("creator is in room %p, want %p", clientRooms[0], room)
+	}
+
+	for _, playerID := range []string{"b", "c"} {
+		joiner, err := dialRoom(serverURL, "r1", playerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer joiner.Close()
+	}
+	waitFor(t, "the joiners to join", func() bool { return len(roomClients(room)) == 3 })
+
+	for _, clientRoom := range roomClients(room) {
+		if clientRoom != room {
+			t.Errorf("joiner is in room %p, want %p", clientRoom, room)
+		}
+	}
+	if registered, _ := rooms.Get("r1"); registered != room {
+		t.Errorf("registry holds room %p, want %p", registered, room)
+	}
+}
+
+func TestCloseRoomShutsTheRoomDown(t *testing.T) {
+	rooms := NewRoomRegistry()
+	gs, serverURL := newTestServer(t, rooms)
+
+	conn, err := dialRoom(serverURL, "r1", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var room *Room
+	waitFor(t, "the player to join", func() bool {
+		room, _ = rooms.Get("r1")
+		return room != nil && len(roomClients(room)) == 1
+	})
+
+	if closed := gs.CloseRoom("r1"); closed != room {
+		t.Fatalf("CloseRoom closed room %p, want %p", closed, room)
+	}
+	if room.Do(func() {}) {
+		t.Error("Do ran on a closed room")
+	}
+	if _, exists := rooms.Get("r1"); exists {
+		t.Error("closed room is still registered")
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); !fastws.IsCloseError(err, fastws.CloseNormalClosure) {
+		t.Errorf("connection was not closed by CloseRoom: %v", err)
+	}
+	if gs.CloseRoom("r1") != nil {
+		t.Error("CloseRoom found a room that was already closed")
+	}
+}
+
+func TestJoinRoomReplacesARoomClosedMidJoin(t *testing.T) {
+	rooms := NewRoomRegistry()
+	gs, serverURL := newTestServer(t, rooms)
+
+	stale, _ := gs.CreateRoom("r1", "a", gotype.GameSettings{})
+
+	// Hold the run loop so the joiner finds the room but cannot join it yet
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	go stale.Do(func() {
+		close(blocked)
+		<-release
+		stale.shutdown()
+	})
+	<-blocked
+
+	conn, err := dialRoom(serverURL, "r1", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	var room *Room
+	waitFor(t, "the player to join a new room", func() bool {
+		room, _ = rooms.Get("r1")
+		return room != nil && len(roomClients(room)) == 1
+	})
+	if room == stale {
+		t.Fatal("player joined the closed room")
+	}
+	if clientRooms := roomClients(room); clientRooms[0] != room {
+		t.Errorf("player is in room %p, want %p", clientRooms[0], room)
+	}
+	if stale.Do(func() {}) {
+		t.Error("Do ran on the closed room")
+	}
+}
+
+func TestCreateRoomRecordsTheHost(t *testing.T) {
+	gs := NewGameRoomService(NewRoomRegistry())
+	room, _ := gs.CreateRoom("r1", "a", gotype.GameSettings{MaxPlayers: 2})
+	defer room.Close()
+
+	hosts := make(map[string]string)
+	var gameState gotype.GameState
+	room.Do(func() {
+		for _, playerID := range []string{"b", "a", "c"} {
+			room.GameService.JoinPlayer(playerID)
+			hosts[playerID] = room.GameService.GetGameState().HostId
+		}
+		gameState = room.GameService.GetGameState()
+	})
+
+	// The first player seated hosts until the creator sits down, and a rejected join changes nothing
+	if hosts["b"] != "b" {
+		t.Errorf("host before the creator joined is %q, want the seated %q", hosts["b"], "b")
+	}
+	if gameState.HostId != "a" {
+		t.Errorf("host is %q, want the creator %q", gameState.HostId, "a")
+	}
+	if len(gameState.Players) != 2 {
+		t.Errorf("room seated %d players, want 2", len(gameState.Players))
+	}
+}
+
+func TestCreatorLeavingBeforeJoiningLeavesTheRoomStartable(t *testing.T) {
+	rooms := NewRoomRegistry()
+	_, serverURL := newTestServer(t, rooms)
+
+	creator, err := dialRoom(serverURL, "r1", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	players := make(map[string]*fastws.Conn)
+	for _, playerID := range []string{"b", "c"} {
+		conn, err := dialRoom(serverURL, "r1", playerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		players[playerID] = conn
+	}
+	var room *Room
+	waitFor(t, "everyone to connect", func() bool {
+		room, _ = rooms.Get("r1")
+		return room != nil && len(roomClients(room)) == 3
+	})
+
+	// The creator drops before taking a seat
+	creator.Close()
+	waitFor(t, "the creator to leave", func() bool { return len(roomClients(room)) == 2 })
+	for _, conn := range players {
+		readState(t, conn)
+	}
+
+	for _, playerID := range []string{"b", "c"} {
+		sendAction(t, players[playerID], JoinAction, playerID, "")
+		readState(t, players[playerID])
+		sendAction(t, players[playerID], ReadyAction, playerID, `{"ready":true}`)
+	}
+	for {
+		msg := readState(t, players["b"])
+		if len(msg.State.Players) == 2 && msg.State.Players[0].Ready && msg.State.Players[1].Ready {
+			break
+		}
+	}
+
+	sendAction(t, players["b"], StartGameAction, "b", "")
+	if msg := readStarted(t, players["b"]); msg.State.HostId != "b" {
+		t.Errorf("host is %q, want the first seated player %q", msg.State.HostId, "b")
+	}
+}
+
+func sendAction(t *testing.T, conn *fastws.Conn, actionType ActionType, playerID string, payload string) {
+	t.Helper()
+
+	msg := ActionMessage{Type: actionType, PlayerId: playerID}
+	if payload != "" {
+		msg.Payload = []byte(payload)
+	}
+	if err := conn.WriteJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readState skips other messages until the next state message.
+func readState(t *testing.T, conn *fastws.Conn) ServerMessage {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg ServerMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type == StateMessage {
+			return msg
+		}
+	}
+}
+
+func TestBroadcastStateHidesSeatsFromOtherConnections(t *testing.T) {
+	rooms := NewRoomRegistry()
+	_, serverURL := newTestServer(t, rooms)
+
+	players := make(map[string]*fastws.Conn)
+	for _, playerID := range []string{"a", "b"} {
+		conn, err := dialRoom(serverURL, "r1", playerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		players[playerID] = conn
+
+		sendAction(t, conn, JoinAction, playerID, "")
+		readState(t, conn)
+		sendAction(t, conn, ReadyAction, playerID, `{"ready":true}`)
+		readState(t, conn)
+	}
+
+	intruder, err := dialRoom(serverURL, "r1", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer intruder.Close()
+	var room *Room
+	waitFor(t, "the intruder to connect", func() bool {
+		room, _ = rooms.Get("r1")
+		return len(roomClients(room)) == 3
+	})
+
+	sendAction(t, players["a"], StartGameAction, "a", "")
+	if msg := readStarted(t, players["a"]); len(msg.LegalActions) == 0 {
+		t.Error("seated player got no legal actions")
+	}
+	if msg := readStarted(t, intruder); len(msg.LegalActions) != 0 {
+		t.Error("connection without the seat got the player's legal actions")
+	}
+}
+
+func readStarted(t *testing.T, conn *fastws.Conn) ServerMessage {
+	t.Helper()
+
+	for {
+		if msg := readState(t, conn); msg.State.State == gotype.Started {
+			return msg
+		}
+	}
+}
+
+func TestPauseRestartsTheNobleTimeout(t *testing.T) {
+	clock := &fakeClock{}
+	gs := newFakeClockService(clock)
+	settings := gotype.GameSettings{NobleChoiceSeconds: 1}
+	room, _ := gs.CreateRoom("r1", "a", settings)
+	defer room.Close()
+
+	phase := func() gotype.TurnPhase {
+		var phase gotype.TurnPhase
+		room.Do(func() { phase = room.GameService.GetGameState().Phase })
+		return phase
+	}
+
+	session := &Session{playerID: "b", client: &Client{playerID: "b"}}
+	var err error
+	room.Do(func() {
+		for _, playerID := range []string{"a", "b"} {
+			room.GameService.JoinPlayer(playerID)
+			room.GameService.SetReady(playerID, true)
+		}
+		if err = room.GameService.StartGame("a"); err != nil {
+			return
+		}
+		game := room.GameService.(*GameServiceImpl)
+		game.GameState.Phase = gotype.NoblePhase
+		game.GameState.PendingNobles = []int{game.GameState.Nobles[0].ID, game.GameState.Nobles[1].ID}
+		room.sessions["b"] = session
+		room.scheduleNobleAutoPick()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	room.Do(func() {
+		session.client = nil
+		room.updatePaused()
+	})
+	if fired := clock.fire(NobleChoiceTimeout(settings)); fired != 0 {
+		t.Fatalf("%d noble timers went off while paused, want 0", fired)
+	}
+	if phase() != gotype.NoblePhase {
+		t.Fatal("noble was picked while the game was paused")
+	}
+
+	room.Do(func() {
+		session.client = &Client{playerID: "b"}
+		room.updatePaused()
+	})
+	if fired := clock.fire(NobleChoiceTimeout(settings)); fired != 1 {
+		t.Fatalf("%d noble timers went off after resuming, want the restarted one", fired)
+	}
+	if phase() == gotype.NoblePhase {
+		t.Error("noble was not picked once the restarted timeout ran out")
+	}
+}
+
+func TestRoomMarshalJSONWhileTheGameMoves(t *testing.T) {
+	gs := NewGameRoomService(NewRoomRegistry())
+	room, _ := gs.CreateRoom("r1", "a", gotype.GameSettings{})
+	defer room.Close()
+
+	var err error
+	room.Do(func() {
+		for _, playerID := range []string{"a", "b"} {
+			room.GameService.JoinPlayer(playerID)
+			room.GameService.SetReady(playerID, true)
+		}
+		err = room.GameService.StartGame("a")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			room.Do(func() {
+				game := room.GameService.(*GameServiceImpl)
+				game.GameState.Gems[gotype.Ruby]++
+				game.GameState.Players[0].Gems[gotype.Ruby]++
+			})
+		}
+	}()
+
+	// Run with -race, the encoder must never read the maps the run loop writes
+	for i := 0; i < 200; i++ {
+		if _, err := json.Marshal(room); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+}
+
+// fakeClock starts room timers that only go off when the test fires them.
+type fakeClock struct {
+	mu     sync.Mutex
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock   *fakeClock
+	d       time.Duration
+	f       func()
+	stopped bool
+}
+
+func newFakeClockService(clock *fakeClock) *GameRoomService {
+	gs := NewGameRoomService(NewRoomRegistry()).(*GameRoomService)
+	gs.afterFunc = clock.AfterFunc
+	return gs
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) RoomTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	timer := &fakeTimer{clock: c, d: d, f: f}
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	pending := !t.stopped
+	t.stopped = true
+	return pending
+}
+
+// fire runs every pending timer started for d, as if d had passed, and
+// reports how many went off.
+func (c *fakeClock) fire(d time.Duration) int {
+	c.mu.Lock()
+	var due []func()
+	for _, timer := range c.timers {
+		if !timer.stopped && timer.d == d {
+			timer.stopped = true
+			due = append(due, timer.f)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, f := range due {
+		f()
+	}
+	return len(due)
+}
+
+func TestCreateRoomClosesWhenNobodyJoins(t *testing.T) {
+	clock := &fakeClock{}
+	gs := newFakeClockService(clock)
+
+	room, _ := gs.CreateRoom("r1", "a", gotype.GameSettings{})
+	if fired := clock.fire(RoomIdleTimeout); fired != 1 {
+		t.Fatalf("%d idle timers went off, want 1", fired)
+	}
+
+	if room.Do(func() {}) {
+		t.Error("Do ran on a room nobody joined")
+	}
+	if _, exists := gs.rooms.Get("r1"); exists {
+		t.Error("room nobody joined is still registered")
+	}
+}
+
+func TestCreateRoomStaysOpenOnceJoined(t *testing.T) {
+	clock := &fakeClock{}
+	gs := newFakeClockService(clock)
+
+	room, _ := gs.CreateRoom("r1", "a", gotype.GameSettings{})
+	defer room.Close()
+	client := &Client{playerID: "a"}
+	room.Do(func() { room.join(client) })
+
+	if fired := clock.fire(RoomIdleTimeout); fired != 0 {
+		t.Errorf("%d idle timers went off after the first join, want 0", fired)
+	}
+	if !room.Do(func() { delete(room.clients, client) }) {
+		t.Error("joined room was closed as idle")
+	}
+}
+
+func TestShutdownAnnouncesTheClosedRoomOnce(t *testing.T) {
+	gs := NewGameRoomService(NewRoomRegistry())
+	sub := gs.Lobby().Subscribe()
+	defer gs.Lobby().Unsubscribe(sub)
+
+	room, _ := gs.CreateRoom("r1", "a", gotype.GameSettings{})
+	// A failed broadcast closes the room before expire and leave get to close it
+	room.Do(func() {
+		room.shutdown()
+		room.closeIfAbandoned()
+		room.shutdown()
+	})
+
+	var events []LobbyEventType
+	for len(sub.Events()) > 0 {
+		events = append(events, (<-sub.Events()).Type)
+	}
+	want := []LobbyEventType{RoomCreatedEvent, RoomClosedEvent}
+	if !slices.Equal(events, want) {
+		t.Errorf("lobby got %v, want %v", events, want)
+	}
+}
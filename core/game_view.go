@@ -0,0 +1,70 @@
+package core
+
+import (
+	"encoding/json"
+
+	"github.com/nuttaponsrpn/go-splendor/gotype"
+)
+
+// ViewForPlayer projects the game state for one viewer. Draw piles are reduced
+// to their counts, opponents' blind reservations only show their level and the
+// seed stays secret until the game ends since it reveals the deck order. An
+// empty viewerId gives the spectator view.
+func ViewForPlayer(gameState gotype.GameState, viewerId string) gotype.GameState {
+	tiles := gameState.DevelopmentTiles
+	gameState.DevelopmentTiles = gotype.DevelopmentTiles{
+		Level1: HideDeck(tiles.Level1),
+		Level2: HideDeck(tiles.Level2),
+		Level3: HideDeck(tiles.Level3),
+	}
+
+	if gameState.State != gotype.End {
+		gameState.Settings.Seed = 0
+	}
+
+	players := make([]gotype.Player, len(gameState.Players))
+	for index, player := range gameState.Players {
+		if player.Id != viewerId {
+			reservedCards := make([]gotype.DevelopmentCard, len(player.ReservedCards))
+			for cardIndex, card := range player.ReservedCards {
+				if card.Blind {
+					card = gotype.DevelopmentCard{Level: card.Level, Blind: true}
+				}
+				reservedCards[cardIndex] = card
+			}
+			player.ReservedCards = reservedCards
+		}
+		players[index] = player
+	}
+	gameState.Players = players
+
+	return gameState
+}
+
+func HideDeck(level gotype.DevelopmentLevel) gotype.DevelopmentLevel {
+	level.DeckCount = len(level.Deck)
+	level.Deck = nil
+	return level
+}
+
+// MarshalJSON only ever exposes a room through the spectator view of its game.
+// The view still shares maps and slices with the live game, so it is encoded
+// on the room's goroutine too.
+func (r *Room) MarshalJSON() ([]byte, error) {
+	var data []byte
+	var err error
+	encodeRoom := func() {
+		data, err = json.Marshal(struct {
+			State     RoomState        `json:"state"`
+			GameState gotype.GameState `json:"gameState"`
+		}{
+			State:     r.state,
+			GameState: ViewForPlayer(r.GameService.GetGameState(), ""),
+		})
+	}
+	// A closed room has no run loop left, so nothing else can touch its state
+	if !r.Do(encodeRoom) {
+		encodeRoom()
+	}
+	return data, err
+}
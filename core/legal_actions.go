@@ -0,0 +1,205 @@
+package core
+
+import (
+	"encoding/json"
+	"slices"
+
+	"github.com/nuttaponsrpn/go-splendor/gotype"
+)
+
+// LegalAction is one action a player may send right now, shaped like the
+// ActionMessage type and payload it stands for.
+type LegalAction struct {
+	Type    ActionType `json:"type"`
+	Payload any        `json:"payload,omitempty"`
+}
+
+// LegalActions enumerates every action the player may take in the current
+// state. It is empty whenever it is not the player's turn.
+func LegalActions(gameState gotype.GameState, playerId string) []LegalAction {
+	playerIndex := slices.IndexFunc(gameState.Players, func(p gotype.Player) bool { return p.Id == playerId })
+	if playerIndex == -1 || gameState.State != gotype.Started || gameState.CurrentPlayerId != playerId {
+		return nil
+	}
+	player := gameState.Players[playerIndex]
+
+	var actions []LegalAction
+	switch gameState.Phase {
+	case gotype.DiscardPhase:
+		for _, gems := range DiscardCombinations(player.Gems, CountPlayerGems(player)-MaxPlayerGems) {
+			actions = append(actions, LegalAction{Type: DiscardAction, Payload: DiscardPayload{Gems: gems}})
+		}
+		return actions
+	case gotype.NoblePhase:
+		for _, nobleId := range gameState.PendingNobles {
+			actions = append(actions, LegalAction{Type: ChooseNobleAction, Payload: ChooseNoblePayload{NobleId: nobleId}})
+		}
+		return actions
+	}
+
+	for _, gems := range GemSelections() {
+		if ValidateSelectedGems(gameState.Gems, gems) == nil {
+			actions = append(actions, LegalAction{Type: TakeGemsAction, Payload: TakeGemsPayload{Gems: gems}})
+		}
+	}
+
+	levels := []gotype.DevelopmentLevel{
+		gameState.DevelopmentTiles.Level1,
+		gameState.DevelopmentTiles.Level2,
+		gameState.DevelopmentTiles.Level3,
+	}
+
+	var purchasable []gotype.DevelopmentCard
+	for _, level := range levels {
+		purchasable = append(purchasable, level.Market...)
+	}
+	purchasable = append(purchasable, player.ReservedCards...)
+	for _, card := range purchasable {
+		if _, err := CalculatePaymentPlan(player, card); err == nil {
+			actions = append(actions, LegalAction{Type: PurchaseAction, Payload: PurchasePayload{CardId: card.ID, Level: card.Level}})
+		}
+	}
+
+	if CheckReserveLimit(player) == nil {
+		for index, level := range levels {
+			for _, card := range level.Market {
+				actions = append(actions, LegalAction{Type: ReserveAction, Payload: ReservePayload{CardId: card.ID, Level: index + 1}})
+			}
+			if len(level.Deck) > 0 || level.DeckCount > 0 {
+				actions = append(actions, LegalAction{Type: ReserveAction, Payload: ReservePayload{Level: index + 1}})
+			}
+		}
+	}
+
+	return actions
+}
+
+// ValidateLegalAction rejects a move of the player to act that is not one of
+// their legal actions. Moves made out of turn are left to the game, which
+// reports why they are not allowed.
+func ValidateLegalAction(gameState gotype.GameState, msg ActionMessage) error {
+	switch msg.Type {
+	case TakeGemsAction, PurchaseAction, ReserveAction, DiscardAction, ChooseNobleAction:
+	default:
+		return nil
+	}
+	if gameState.State != gotype.Started || gameState.Paused || msg.PlayerId != gameState.CurrentPlayerId {
+		return nil
+	}
+
+	if _, err := ActionKey(msg); err != nil {
+		return err
+	}
+	if !IsLegalAction(gameState, msg) {
+		return NewGameError(CodeInvalidAction, string(msg.Type)+" is not one of the legal actions")
+	}
+	return nil
+}
+
+// IsLegalAction reports whether the message is one of the sender's legal actions.
+func IsLegalAction(gameState gotype.GameState, msg ActionMessage) bool {
+	key, err := ActionKey(msg)
+	if err != nil {
+		return false
+	}
+
+	return slices.ContainsFunc(LegalActions(gameState, msg.PlayerId), func(action LegalAction) bool {
+		payload, err := json.Marshal(action.Payload)
+		if err != nil {
+			return false
+		}
+		legalKey, err := ActionKey(ActionMessage{Type: action.Type, Payload: payload})
+		return err == nil && legalKey == key
+	})
+}
+
+// ActionKey normalizes an action so equivalent messages compare equal, e.g.
+// gems listed in a different order.
+func ActionKey(msg ActionMessage) (string, error) {
+	var payload any
+	switch msg.Type {
+	case TakeGemsAction:
+		takeGems, err := DecodePayload[TakeGemsPayload](msg)
+		if err != nil {
+			return "", err
+		}
+		takeGems.Gems = slices.Clone(takeGems.Gems)
+		slices.Sort(takeGems.Gems)
+		payload = takeGems
+	case DiscardAction:
+		discard, err := DecodePayload[DiscardPayload](msg)
+		if err != nil {
+			return "", err
+		}
+		discard.Gems = slices.Clone(discard.Gems)
+		slices.Sort(discard.Gems)
+		payload = discard
+	case PurchaseAction:
+		purchase, err := DecodePayload[PurchasePayload](msg)
+		if err != nil {
+			return "", err
+		}
+		payload = purchase
+	case ReserveAction:
+		reserve, err := DecodePayload[ReservePayload](msg)
+		if err != nil {
+			return "", err
+		}
+		payload = reserve
+	case ChooseNobleAction:
+		chooseNoble, err := DecodePayload[ChooseNoblePayload](msg)
+		if err != nil {
+			return "", err
+		}
+		payload = chooseNoble
+	}
+
+	key, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	return string(msg.Type) + ":" + string(key), nil
+}
+
+// GemSelections lists every candidate take-gems move: one, two or three
+// distinct colors and two of the same color.
+func GemSelections() [][]gotype.GemType {
+	var selections [][]gotype.GemType
+	for i, first := range GemColors {
+		selections = append(selections, []gotype.GemType{first}, []gotype.GemType{first, first})
+		for j := i + 1; j < len(GemColors); j++ {
+			selections = append(selections, []gotype.GemType{first, GemColors[j]})
+			for k := j + 1; k < len(GemColors); k++ {
+				selections = append(selections, []gotype.GemType{first, GemColors[j], GemColors[k]})
+			}
+		}
+	}
+	return selections
+}
+
+// DiscardCombinations lists every distinct set of count tokens a player can
+// give back from their gems.
+func DiscardCombinations(gems map[gotype.GemType]int, count int) [][]gotype.GemType {
+	gemTypes := append(slices.Clone(GemColors), gotype.Joker)
+
+	var combinations [][]gotype.GemType
+	var collect func(typeIndex int, remaining int, discarded []gotype.GemType)
+	collect = func(typeIndex int, remaining int, discarded []gotype.GemType) {
+		if remaining == 0 {
+			combinations = append(combinations, slices.Clone(discarded))
+			return
+		}
+		if typeIndex == len(gemTypes) {
+			return
+		}
+
+		gemType := gemTypes[typeIndex]
+		for taken := 0; taken <= min(remaining, gems[gemType]); taken++ {
+			collect(typeIndex+1, remaining-taken, discarded)
+			discarded = append(discarded, gemType)
+		}
+	}
+	collect(0, count, nil)
+
+	return combinations
+}
@@ -0,0 +1,321 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nuttaponsrpn/go-splendor/gotype"
+)
+
+// startedGame is a running game on the first player's action with an empty
+// bank and table, for tests to fill in.
+func startedGame(players ...gotype.Player) gotype.GameState {
+	for index := range players {
+		if players[index].Gems == nil {
+			players[index].Gems = make(map[gotype.GemType]int)
+		}
+	}
+	return gotype.GameState{
+		State:           gotype.Started,
+		Phase:           gotype.ActionPhase,
+		Players:         players,
+		CurrentPlayerId: players[0].Id,
+		Gems:            make(map[gotype.GemType]int),
+	}
+}
+
+func errorCode(err error) GameErrorCode {
+	var gameErr *GameError
+	if errors.As(err, &gameErr) {
+		return gameErr.Code
+	}
+	return ""
+}
+
+// legalPayloads lists the payloads of the legal actions of one type, with gem
+// lists sorted so they compare in any order.
+func legalPayloads(gameState gotype.GameState, playerId string, actionType ActionType) []string {
+	var payloads []string
+	for _, action := range LegalActions(gameState, playerId) {
+		if action.Type != actionType {
+			continue
+		}
+		var gems []gotype.GemType
+		switch payload := action.Payload.(type) {
+		case TakeGemsPayload:
+			gems = payload.Gems
+		case DiscardPayload:
+			gems = payload.Gems
+		case PurchasePayload:
+			payloads = append(payloads, fmt.Sprintf("level %d card %d", payload.Level, payload.CardId))
+			continue
+		}
+		gems = slices.Clone(gems)
+		slices.Sort(gems)
+		var names []string
+		for _, gem := range gems {
+			names = append(names, string(gem))
+		}
+		payloads = append(payloads, strings.Join(names, " "))
+	}
+	slices.Sort(payloads)
+	return payloads
+}
+
+func TestValidateSelectedGems(t *testing.T) {
+	tests := []struct {
+		name     string
+		bank     map[gotype.GemType]int
+		selected []gotype.GemType
+		wantErr  bool
+	}{
+		{"two of a color with four left", map[gotype.GemType]int{gotype.Ruby: 4}, []gotype.GemType{gotype.Ruby, gotype.Ruby}, false},
+		{"two of a color with three left", map[gotype.GemType]int{gotype.Ruby: 3, gotype.Onyx: 4, gotype.Diamond: 4}, []gotype.GemType{gotype.Ruby, gotype.Ruby}, true},
+		{"three colors", map[gotype.GemType]int{gotype.Ruby: 1, gotype.Onyx: 1, gotype.Diamond: 1}, []gotype.GemType{gotype.Ruby, gotype.Onyx, gotype.Diamond}, false},
+		{"two colors while three are left", map[gotype.GemType]int{gotype.Ruby: 1, gotype.Onyx: 1, gotype.Diamond: 1}, []gotype.GemType{gotype.Ruby, gotype.Onyx}, true},
+		{"two colors while two are left", map[gotype.GemType]int{gotype.Ruby: 1, gotype.Onyx: 1}, []gotype.GemType{gotype.Ruby, gotype.Onyx}, false},
+		{"one color while one is left", map[gotype.GemType]int{gotype.Ruby: 2}, []gotype.GemType{gotype.Ruby}, false},
+		{"one color while two are left", map[gotype.GemType]int{gotype.Ruby: 2, gotype.Onyx: 1}, []gotype.GemType{gotype.Ruby}, true},
+		{"a color the bank ran out of", map[gotype.GemType]int{gotype.Ruby: 1, gotype.Onyx: 1}, []gotype.GemType{gotype.Ruby, gotype.Onyx, gotype.Diamond}, true},
+		{"the same color twice among three", map[gotype.GemType]int{gotype.Ruby: 4, gotype.Onyx: 4}, []gotype.GemType{gotype.Ruby, gotype.Ruby, gotype.Onyx}, true},
+		{"a joker", map[gotype.GemType]int{gotype.Joker: 5}, []gotype.GemType{gotype.Joker}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateSelectedGems(test.bank, test.selected)
+			if test.wantErr && errorCode(err) != CodeInvalidGemSelection {
+				t.Errorf("got %v, want %s", err, CodeInvalidGemSelection)
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("got %v, want the selection accepted", err)
+			}
+		})
+	}
+}
+
+func TestLegalActionsTakeGemsAtTheBankEdge(t *testing.T) {
+	tests := []struct {
+		name string
+		bank map[gotype.GemType]int
+		want []string
+	}{
+		{"two of a color only with four left", map[gotype.GemType]int{gotype.Ruby: 4, gotype.Onyx: 3}, []string{"onyx ruby", "ruby ruby"}},
+		{"one color left", map[gotype.GemType]int{gotype.Ruby: 2}, []string{"ruby"}},
+		{"jokers only", map[gotype.GemType]int{gotype.Joker: 5}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gameState := startedGame(gotype.Player{Id: "a"})
+			gameState.Gems = test.bank
+
+			if got := legalPayloads(gameState, "a", TakeGemsAction); !slices.Equal(got, test.want) {
+				t.Errorf("legal gem takes are %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCalculatePaymentPlan(t *testing.T) {
+	rubyCard := gotype.DevelopmentCard{ID: 1, Level: 1, GemType: gotype.Ruby}
+
+	tests := []struct {
+		name       string
+		player     gotype.Player
+		cost       gotype.Gems
+		want       map[gotype.GemType]int
+		cantAfford bool
+	}{
+		{
+			name:   "colored tokens only",
+			player: gotype.Player{Gems: map[gotype.GemType]int{gotype.Ruby: 2, gotype.Joker: 1}},
+			cost:   gotype.Gems{Ruby: 2},
+			want:   map[gotype.GemType]int{gotype.Ruby: 2},
+		},
+		{
+			name:   "jokers cover the shortfall",
+			player: gotype.Player{Gems: map[gotype.GemType]int{gotype.Ruby: 1, gotype.Joker: 3}},
+			cost:   gotype.Gems{Ruby: 3, Onyx: 1},
+			want:   map[gotype.GemType]int{gotype.Ruby: 1, gotype.Joker: 3},
+		},
+		{
+			name:   "purchased cards discount before jokers",
+			player: gotype.Player{Gems: map[gotype.GemType]int{gotype.Ruby: 1, gotype.Joker: 1}, PurchaseCards: []gotype.DevelopmentCard{rubyCard}},
+			cost:   gotype.Gems{Ruby: 3},
+			want:   map[gotype.GemType]int{gotype.Ruby: 1, gotype.Joker: 1},
+		},
+		{
+			name:       "not enough jokers",
+			player:     gotype.Player{Gems: map[gotype.GemType]int{gotype.Ruby: 1, gotype.Joker: 1}},
+			cost:       gotype.Gems{Ruby: 3},
+			cantAfford: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payment, err := CalculatePaymentPlan(test.player, gotype.DevelopmentCard{ID: 2, Level: 1, Cost: test.cost})
+			if test.cantAfford {
+				if errorCode(err) != CodeCannotAfford {
+					t.Errorf("got %v, want %s", err, CodeCannotAfford)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			maps.DeleteFunc(payment, func(_ gotype.GemType, count int) bool { return count == 0 })
+			if !maps.Equal(payment, test.want) {
+				t.Errorf("payment is %v, want %v", payment, test.want)
+			}
+		})
+	}
+}
+
+func TestLegalActionsPurchaseWithJokers(t *testing.T) {
+	gameState := startedGame(gotype.Player{
+		Id:            "a",
+		Gems:          map[gotype.GemType]int{gotype.Ruby: 1, gotype.Joker: 1},
+		ReservedCards: []gotype.DevelopmentCard{{ID: 1, Level: 2, Cost: gotype.Gems{Onyx: 1}}},
+	})
+	gameState.DevelopmentTiles.Level1.Market = []gotype.DevelopmentCard{
+		{ID: 1, Level: 1, Cost: gotype.Gems{Ruby: 2}},
+		{ID: 2, Level: 1, Cost: gotype.Gems{Ruby: 3}},
+	}
+
+	want := []string{"level 1 card 1", "level 2 card 1"}
+	if got := legalPayloads(gameState, "a", PurchaseAction); !slices.Equal(got, want) {
+		t.Errorf("legal purchases are %q, want %q", got, want)
+	}
+
+	purchase := func(payload string) ActionMessage {
+		return ActionMessage{Type: PurchaseAction, PlayerId: "a", Payload: []byte(payload)}
+	}
+	if err := ValidateLegalAction(gameState, purchase(`{"cardId":1,"level":1}`)); err != nil {
+		t.Errorf("buying with a joker was rejected: %v", err)
+	}
+	if err := ValidateLegalAction(gameState, purchase(`{"cardId":2,"level":1}`)); errorCode(err) != CodeInvalidAction {
+		t.Errorf("got %v for an unaffordable card, want %s", err, CodeInvalidAction)
+	}
+}
+
+func TestDiscardCombinations(t *testing.T) {
+	tests := []struct {
+		name string
+		gems map[gotype.GemType]int
+		want int
+	}{
+		{"few colors and a joker", map[gotype.GemType]int{gotype.Ruby: 10, gotype.Diamond: 2, gotype.Joker: 1}, 6},
+		{"every color", map[gotype.GemType]int{gotype.Diamond: 3, gotype.Sapphire: 3, gotype.Emerald: 3, gotype.Ruby: 2, gotype.Onyx: 2}, 33},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			combinations := DiscardCombinations(test.gems, 3)
+			if len(combinations) != test.want {
+				t.Errorf("got %d combinations, want %d", len(combinations), test.want)
+			}
+
+			seen := make(map[string]bool)
+			for _, gems := range combinations {
+				counts := make(map[gotype.GemType]int)
+				for _, gem := range gems {
+					counts[gem]++
+				}
+				if len(gems) != 3 {
+					t.Errorf("%v discards %d tokens, want 3", gems, len(gems))
+				}
+				for gem, count := range counts {
+					if count > test.gems[gem] {
+						t.Errorf("%v discards more %s than the player holds", gems, gem)
+					}
+				}
+
+				key := fmt.Sprint(counts)
+				if seen[key] {
+					t.Errorf("%v is listed twice", gems)
+				}
+				seen[key] = true
+			}
+		})
+	}
+}
+
+func TestLegalActionsDiscardWithThirteenTokens(t *testing.T) {
+	gameState := startedGame(gotype.Player{
+		Id:   "a",
+		Gems: map[gotype.GemType]int{gotype.Ruby: 10, gotype.Diamond: 2, gotype.Joker: 1},
+	})
+	gameState.Phase = gotype.DiscardPhase
+
+	want := []string{
+		"diamond diamond joker",
+		"diamond diamond ruby",
+		"diamond joker ruby",
+		"diamond ruby ruby",
+		"joker ruby ruby",
+		"ruby ruby ruby",
+	}
+	if got := legalPayloads(gameState, "a", DiscardAction); !slices.Equal(got, want) {
+		t.Errorf("legal discards are %q, want %q", got, want)
+	}
+
+	discard := func(payload string) ActionMessage {
+		return ActionMessage{Type: DiscardAction, PlayerId: "a", Payload: []byte(payload)}
+	}
+	if err := ValidateLegalAction(gameState, discard(`{"gems":["ruby","joker","diamond"]}`)); err != nil {
+		t.Errorf("discard listed in another order was rejected: %v", err)
+	}
+	if err := ValidateLegalAction(gameState, discard(`{"gems":["ruby","ruby"]}`)); errorCode(err) != CodeInvalidAction {
+		t.Errorf("got %v for a short discard, want %s", err, CodeInvalidAction)
+	}
+}
+
+func TestSkipBlockedPlayers(t *testing.T) {
+	t.Run("a fully blocked table ends the game", func(t *testing.T) {
+		game := NewGameService(startedGame(gotype.Player{Id: "a"}, gotype.Player{Id: "b"})).(*GameServiceImpl)
+
+		if err := game.SkipBlockedPlayers(); err != nil {
+			t.Fatal(err)
+		}
+		if game.GameState.State != gotype.End {
+			t.Errorf("game is %s, want %s", game.GameState.State, gotype.End)
+		}
+		if len(game.GameState.Standings) != 2 {
+			t.Errorf("got %d standings, want 2", len(game.GameState.Standings))
+		}
+		if events := game.DrainEvents(); len(events) != 2 {
+			t.Errorf("got %d auto passes, want one per player", len(events))
+		}
+	})
+
+	t.Run("the turn passes to the next player who can move", func(t *testing.T) {
+		gameState := startedGame(
+			gotype.Player{Id: "a"},
+			gotype.Player{Id: "b", Gems: map[gotype.GemType]int{gotype.Joker: 1}},
+		)
+		gameState.DevelopmentTiles.Level1.Market = []gotype.DevelopmentCard{{ID: 1, Level: 1, Cost: gotype.Gems{Ruby: 1}}}
+		// a may not reserve and cannot afford their reservations, which leaves them nothing to do
+		for cardId := 1; cardId <= MaxReservedCards; cardId++ {
+			gameState.Players[0].ReservedCards = append(gameState.Players[0].ReservedCards, gotype.DevelopmentCard{ID: cardId, Level: 2, Cost: gotype.Gems{Onyx: 1}})
+		}
+		game := NewGameService(gameState).(*GameServiceImpl)
+
+		if err := game.SkipBlockedPlayers(); err != nil {
+			t.Fatal(err)
+		}
+		if game.GameState.State != gotype.Started || game.GameState.CurrentPlayerId != "b" {
+			t.Errorf("game is %s on %q, want %s on %q", game.GameState.State, game.GameState.CurrentPlayerId, gotype.Started, "b")
+		}
+		events := game.DrainEvents()
+		if len(events) != 1 || events[0].Type != AutoPassEvent || events[0].PlayerId != "a" {
+			t.Errorf("got events %v, want one auto pass for %q", events, "a")
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package core
+
+import "sync"
+
+type LobbyEventType string
+
+const (
+	RoomCreatedEvent    LobbyEventType = "room_created"
+	PlayersChangedEvent LobbyEventType = "players_changed"
+	GameStartedEvent    LobbyEventType = "game_started"
+	RoomClosedEvent     LobbyEventType = "room_closed"
+)
+
+// LobbyEvent carries the room as the lobby lists it, so subscribers can apply
+// it over their GetRoom snapshot.
+type LobbyEvent struct {
+	Type LobbyEventType `json:"type"`
+	DisplayRooms
+}
+
+const LobbyQueueSize = 32
+
+// LobbyHub fans lobby events out to its subscribers. Publish never blocks:
+// every subscriber reads from its own buffered queue, and a subscriber that
+// lets its queue fill up is dropped and its channel closed.
+type LobbyHub struct {
+	mu          sync.Mutex
+	subscribers map[*LobbySubscriber]struct{}
+}
+
+type LobbySubscriber struct {
+	events chan LobbyEvent
+}
+
+func NewLobbyHub() *LobbyHub {
+	return &LobbyHub{subscribers: make(map[*LobbySubscriber]struct{})}
+}
+
+// Events is closed when the subscriber unsubscribes or is dropped for being too slow.
+func (sub *LobbySubscriber) Events() <-chan LobbyEvent {
+	return sub.events
+}
+
+func (h *LobbyHub) Subscribe() *LobbySubscriber {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := &LobbySubscriber{events: make(chan LobbyEvent, LobbyQueueSize)}
+	h.subscribers[sub] = struct{}{}
+	return sub
+}
+
+func (h *LobbyHub) Unsubscribe(sub *LobbySubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.drop(sub)
+}
+
+func (h *LobbyHub) Publish(event LobbyEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			h.drop(sub)
+		}
+	}
+}
+
+func (h *LobbyHub) drop(sub *LobbySubscriber) {
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
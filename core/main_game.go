@@ -2,51 +2,68 @@ package core
 
 import (
 	"errors"
-	"log"
 	"math/rand"
 	"slices"
 	"strconv"
+	"time"
 
 	"github.com/nuttaponsrpn/go-splendor/gotype"
 )
 
-type WebsocketPlayerAction struct {
-	PlayerId      string                 `json:"playerId"`
-	SelectedGems  []gotype.GemType       `json:"selectedGems"`
-	PurchasedCard gotype.DevelopmentCard `json:"purchasedCard"`
-	ReservedCard  gotype.DevelopmentCard `json:"reservedCard"`
-	Status        gotype.Status          `json:"status"`
+const (
+	MaxPlayerGems    = 10
+	MaxReservedCards = 3
+	MarketSize       = 4
+	WinningPoints    = 15
+	MinPlayers       = 2
+	MaxPlayers       = 4
+
+	DefaultNobleChoiceSeconds = 30
+	DefaultReconnectSeconds   = 60
+)
+
+var GemColors = []gotype.GemType{
+	gotype.Diamond,
+	gotype.Sapphire,
+	gotype.Emerald,
+	gotype.Ruby,
+	gotype.Onyx,
 }
 
 type GameService interface {
 	GetGameState() gotype.GameState
-	JoinPlayer(playerId string)
-	RemovePlayer(playerId string)
-	UpdateGameState(action WebsocketPlayerAction) error
+	JoinPlayer(playerId string) error
+	SetReady(playerId string, ready bool) error
+	StartGame(playerId string) error
+	RemovePlayer(playerId string) error
+	SetPaused(paused bool)
+	TakeGems(playerId string, gems []gotype.GemType) error
+	PurchaseCard(playerId string, cardId int, level int) error
+	ReserveCard(playerId string, cardId int, level int) error
+	DiscardGems(playerId string, gems []gotype.GemType) error
+	ChooseNoble(playerId string, nobleId int) error
+	AutoChooseNoble(turn int) error
+	DrainEvents() []GameEvent
 }
 
 type GameServiceImpl struct {
 	GameState gotype.GameState
+	events    []GameEvent
 }
 
 func NewGameService(GameState gotype.GameState) GameService {
 	return &GameServiceImpl{GameState: GameState}
 }
 
+// GetGameState returns the full server-side state, hidden information
+// included. Use ViewForPlayer before sending it to anyone.
 func (s *GameServiceImpl) GetGameState() gotype.GameState {
-	fmtGameState := s.GameState
-	tiles := fmtGameState.DevelopmentTiles
-
-	if len(tiles.Level1) > 0 {
-		fmtGameState.DevelopmentTiles.Level1 = tiles.Level1[0:4]
-		fmtGameState.DevelopmentTiles.Level2 = tiles.Level2[0:4]
-		fmtGameState.DevelopmentTiles.Level3 = tiles.Level3[0:4]
-	}
-
-	return fmtGameState
+	return s.GameState
 }
 
-func (s *GameServiceImpl) JoinPlayer(playerId string) {
+// JoinPlayer seats a new player while the game is waiting and the room has
+// space. Joining again as a seated player is a no-op.
+func (s *GameServiceImpl) JoinPlayer(playerId string) error {
 	players := s.GameState.Players
 	isPlayerExist := slices.ContainsFunc(players, func(p gotype.Player) bool {
 		return p.Id == playerId
@@ -57,11 +74,16 @@ func (s *GameServiceImpl) JoinPlayer(playerId string) {
 	}
 
 	if isPlayerExist {
-		return
+		return nil
+	}
+
+	if s.GameState.State != gotype.Waiting {
+		return NewGameError(CodeGameStarted, "game has already started")
 	}
 
-	if s.GameState.DevelopmentTiles.Level1 == nil {
-		InitGameCard(&s.GameState)
+	_, maxPlayers := PlayerLimits(s.GameState.Settings)
+	if len(players) >= maxPlayers {
+		return NewGameError(CodeRoomFull, "room is full, it takes "+strconv.Itoa(maxPlayers)+" players")
 	}
 
 	newPlayer := gotype.Player{
@@ -80,143 +102,587 @@ func (s *GameServiceImpl) JoinPlayer(playerId string) {
 		NobleCards:    []gotype.NobleCard{},
 	}
 	s.GameState.Players = append(s.GameState.Players, newPlayer)
+
+	// The creator hosts once seated, until then the first player to sit down does
+	if playerId == s.GameState.CreatorId || s.GameState.HostId == "" {
+		s.GameState.HostId = playerId
+	}
+	return nil
 }
 
-func (s *GameServiceImpl) RemovePlayer(playerId string) {
+// RemovePlayer takes the player out of the room. Leaving a running game hands
+// the turn to the next seat, and the game ends once too few players are left.
+func (s *GameServiceImpl) RemovePlayer(playerId string) error {
 
 	if s.GameState.Players == nil || len(s.GameState.Players) <= 0 {
-		return
+		return nil
 	}
 
 	removeIndex := slices.IndexFunc(s.GameState.Players, func(p gotype.Player) bool { return p.Id == playerId })
 	if removeIndex != -1 {
 		s.GameState.Players = append(s.GameState.Players[:removeIndex], s.GameState.Players[removeIndex+1:]...)
 	}
+
+	// The next seated player takes over from a host who leaves
+	if s.GameState.HostId == playerId {
+		s.GameState.HostId = ""
+		if len(s.GameState.Players) > 0 {
+			s.GameState.HostId = s.GameState.Players[0].Id
+		}
+	}
+
+	if removeIndex == -1 || s.GameState.State != gotype.Started {
+		return nil
+	}
+	s.events = append(s.events, GameEvent{Type: PlayerLeftEvent, PlayerId: playerId})
+
+	if len(s.GameState.Players) < MinPlayers {
+		s.EndGame()
+		return nil
+	}
+	if playerId != s.GameState.CurrentPlayerId {
+		return nil
+	}
+
+	// The seat after the leaver now sits at removeIndex, wrapping past the last seat
+	if removeIndex == len(s.GameState.Players) {
+		if s.GameState.FinalRound {
+			s.EndGame()
+			return nil
+		}
+		removeIndex = 0
+	}
+	s.GameState.CurrentPlayerId = s.GameState.Players[removeIndex].Id
+	s.GameState.Phase = gotype.ActionPhase
+	s.GameState.PendingNobles = nil
+	s.GameState.Turn++
+	return s.SkipBlockedPlayers()
+}
+
+// SetPaused pauses or resumes a running game, other games are never paused.
+func (s *GameServiceImpl) SetPaused(paused bool) {
+	s.GameState.Paused = paused && s.GameState.State == gotype.Started
+}
+
+func (s *GameServiceImpl) SetReady(playerId string, ready bool) error {
+	if s.GameState.State != gotype.Waiting {
+		return NewGameError(CodeGameStarted, "game has already started")
+	}
+
+	playerIndex := slices.IndexFunc(s.GameState.Players, func(p gotype.Player) bool { return p.Id == playerId })
+	if playerIndex == -1 {
+		return NewGameError(CodePlayerNotFound, "not found player: "+playerId)
+	}
+
+	s.GameState.Players[playerIndex].Ready = ready
+	return nil
+}
+
+// StartGame deals the game when the host asks for it, once the room has enough
+// players and every one of them is ready.
+func (s *GameServiceImpl) StartGame(playerId string) error {
+	if s.GameState.State != gotype.Waiting {
+		return NewGameError(CodeGameStarted, "game has already started")
+	}
+
+	if playerId != s.GameState.HostId {
+		return NewGameError(CodeNotHost, "only the host "+s.GameState.HostId+" can start the game")
+	}
+
+	minPlayers, maxPlayers := PlayerLimits(s.GameState.Settings)
+	if playerCount := len(s.GameState.Players); playerCount < minPlayers || playerCount > maxPlayers {
+		return NewGameError(CodeInvalidPlayerCount, "game needs "+strconv.Itoa(minPlayers)+" to "+strconv.Itoa(maxPlayers)+" players")
+	}
+
+	notReady := slices.IndexFunc(s.GameState.Players, func(p gotype.Player) bool { return !p.Ready })
+	if notReady != -1 {
+		return NewGameError(CodePlayersNotReady, "waiting for player "+s.GameState.Players[notReady].Id+" to be ready")
+	}
+
+	if err := InitGameCard(&s.GameState); err != nil {
+		return err
+	}
+	s.GameState.CurrentPlayerId = s.GameState.Players[0].Id
+	s.GameState.State = gotype.Started
+	return nil
 }
 
-func InitGameCard(game *gotype.GameState) {
-	developmentTiles, nobles := RandomCards()
+func InitGameCard(game *gotype.GameState) error {
+	gemCount, nobleCount, err := SetupForPlayers(len(game.Players), game.Settings.Variant)
+	if err != nil {
+		return err
+	}
+
+	rng := rand.New(rand.NewSource(game.Settings.Seed))
+	developmentTiles, nobles := RandomCards(rng, nobleCount)
 	game.Nobles = nobles
 	game.DevelopmentTiles = *developmentTiles
+	game.Phase = gotype.ActionPhase
 	game.Gems = map[gotype.GemType]int{
-		gotype.Diamond:  7,
-		gotype.Sapphire: 7,
-		gotype.Emerald:  7,
-		gotype.Ruby:     7,
-		gotype.Onyx:     7,
+		gotype.Diamond:  gemCount,
+		gotype.Sapphire: gemCount,
+		gotype.Emerald:  gemCount,
+		gotype.Ruby:     gemCount,
+		gotype.Onyx:     gemCount,
 		gotype.Joker:    5,
 	}
+	return nil
 }
 
-func RandomCards() (*gotype.DevelopmentTiles, []gotype.NobleCard) {
-	developmentTiles := &gotype.DevelopmentTiles{
-		Level1: DevelopmentLevel1,
-		Level2: DevelopmentLevel2,
-		Level3: DevelopmentLevel3,
+// SetupForPlayers returns the tokens per color and the nobles dealt for the
+// number of players: 4/5/7 tokens for 2/3/4 players and one noble more than players.
+func SetupForPlayers(playerCount int, variant gotype.SetupVariant) (int, int, error) {
+	if playerCount < MinPlayers || playerCount > MaxPlayers {
+		return 0, 0, NewGameError(CodeInvalidPlayerCount, "game needs "+strconv.Itoa(MinPlayers)+" to "+strconv.Itoa(MaxPlayers)+" players")
 	}
 
-	ShuffleCard(developmentTiles.Level1)
-	ShuffleCard(developmentTiles.Level2)
-	ShuffleCard(developmentTiles.Level3)
+	if variant == gotype.FullSetup {
+		return 7, MaxPlayers + 1, nil
+	}
 
-	nobles := Nobles
+	switch playerCount {
+	case 2:
+		return 4, playerCount + 1, nil
+	case 3:
+		return 5, playerCount + 1, nil
+	}
+	return 7, playerCount + 1, nil
+}
 
-	ShuffleCard(nobles)
-	nobles = nobles[0:4]
+func RandomCards(rng *rand.Rand, nobleCount int) (*gotype.DevelopmentTiles, []gotype.NobleCard) {
+	deck := NewDeck()
+
+	ShuffleCard(rng, deck.Level1)
+	ShuffleCard(rng, deck.Level2)
+	ShuffleCard(rng, deck.Level3)
+
+	developmentTiles := &gotype.DevelopmentTiles{
+		Level1: NewDevelopmentLevel(deck.Level1),
+		Level2: NewDevelopmentLevel(deck.Level2),
+		Level3: NewDevelopmentLevel(deck.Level3),
+	}
+
+	nobles := deck.Nobles
+
+	ShuffleCard(rng, nobles)
+	nobles = nobles[0:nobleCount]
 
 	return developmentTiles, nobles
 }
 
-func ShuffleCard[T gotype.DevelopmentCard | gotype.NobleCard](card []T) {
+// NewDevelopmentLevel deals the face-up market from the top of a shuffled level
+// and keeps the rest as its draw pile.
+func NewDevelopmentLevel(cards []gotype.DevelopmentCard) gotype.DevelopmentLevel {
+	marketSize := min(MarketSize, len(cards))
+	return gotype.DevelopmentLevel{
+		Market:    cards[:marketSize:marketSize],
+		Deck:      cards[marketSize:],
+		DeckCount: len(cards) - marketSize,
+	}
+}
+
+func ShuffleCard[T gotype.DevelopmentCard | gotype.NobleCard](rng *rand.Rand, card []T) {
 	for i := range card {
-		j := rand.Intn(i + 1)
+		j := rng.Intn(i + 1)
 		card[i], card[j] = card[j], card[i]
 	}
 }
 
-func GetMessage() {}
-
-func (s *GameServiceImpl) UpdateGameState(Action WebsocketPlayerAction) error {
-	playerIndex := slices.IndexFunc(s.GameState.Players, func(p gotype.Player) bool { return p.Id == Action.PlayerId })
+// GetTurnPlayer returns the acting player when the game is running, it is their
+// turn and the turn is waiting for an action of the given phase.
+func (s *GameServiceImpl) GetTurnPlayer(playerId string, phase gotype.TurnPhase) (*gotype.Player, error) {
+	playerIndex := slices.IndexFunc(s.GameState.Players, func(p gotype.Player) bool { return p.Id == playerId })
 
 	if playerIndex == -1 {
-		return errors.New("not found player: " + Action.PlayerId)
+		return nil, NewGameError(CodePlayerNotFound, "not found player: "+playerId)
 	}
 
-	currentPlayer := &s.GameState.Players[playerIndex]
+	if s.GameState.State == gotype.End {
+		return nil, NewGameError(CodeGameEnded, "game has already ended")
+	}
+
+	if s.GameState.State != gotype.Started {
+		return nil, NewGameError(CodeGameNotStarted, "game has not started")
+	}
+
+	if s.GameState.Paused {
+		return nil, NewGameError(CodeGamePaused, "game is paused until every player reconnects")
+	}
+
+	if playerId != s.GameState.CurrentPlayerId {
+		return nil, NewGameError(CodeNotYourTurn, "waiting for player "+s.GameState.CurrentPlayerId)
+	}
+
+	if s.GameState.Phase != phase {
+		switch s.GameState.Phase {
+		case gotype.DiscardPhase:
+			return nil, NewGameError(CodeMustDiscard, "must discard gems before the turn can end")
+		case gotype.NoblePhase:
+			return nil, NewGameError(CodeMustChooseNoble, "must choose a noble before the turn can end")
+		}
+		return nil, NewGameError(CodeInvalidAction, "action is not allowed during the "+string(s.GameState.Phase)+" phase")
+	}
+
+	return &s.GameState.Players[playerIndex], nil
+}
+
+func (s *GameServiceImpl) TakeGems(playerId string, gems []gotype.GemType) error {
+	currentPlayer, err := s.GetTurnPlayer(playerId, gotype.ActionPhase)
+	if err != nil {
+		return err
+	}
+
+	if err := s.UpdatePlayerGems(currentPlayer, gems); err != nil {
+		return err
+	}
+	return s.EndTurn(currentPlayer)
+}
+
+func (s *GameServiceImpl) PurchaseCard(playerId string, cardId int, level int) error {
+	currentPlayer, err := s.GetTurnPlayer(playerId, gotype.ActionPhase)
+	if err != nil {
+		return err
+	}
+
+	if err := s.UpdatedPlayerPurchasedCard(currentPlayer, cardId, level); err != nil {
+		return err
+	}
+	return s.EndTurn(currentPlayer)
+}
+
+// ReserveCard reserves a face-up card of the level by id, or the top of the
+// level's draw pile when no card id is given.
+func (s *GameServiceImpl) ReserveCard(playerId string, cardId int, level int) error {
+	currentPlayer, err := s.GetTurnPlayer(playerId, gotype.ActionPhase)
+	if err != nil {
+		return err
+	}
+
+	if cardId != 0 {
+		err = s.UpdatedPlayerReservedCard(currentPlayer, cardId, level)
+	} else {
+		err = s.ReserveDeckCard(currentPlayer, level)
+	}
+	if err != nil {
+		return err
+	}
+	return s.EndTurn(currentPlayer)
+}
+
+func (s *GameServiceImpl) DiscardGems(playerId string, gems []gotype.GemType) error {
+	currentPlayer, err := s.GetTurnPlayer(playerId, gotype.DiscardPhase)
+	if err != nil {
+		return err
+	}
+
+	if err := s.DiscardPlayerGems(currentPlayer, gems); err != nil {
+		return err
+	}
+	return s.EndTurn(currentPlayer)
+}
 
-	s.UpdatePlayerGems(currentPlayer, Action.SelectedGems)
-	s.UpdatedPlayerPurchasedCard(currentPlayer, Action.PurchasedCard)
-	s.UpdatedPlayerReservedCard(currentPlayer, Action.ReservedCard)
-	s.AddNobleCard(currentPlayer)
+func (s *GameServiceImpl) ChooseNoble(playerId string, nobleId int) error {
+	currentPlayer, err := s.GetTurnPlayer(playerId, gotype.NoblePhase)
+	if err != nil {
+		return err
+	}
+
+	if err := s.GrantPendingNoble(currentPlayer, nobleId); err != nil {
+		return err
+	}
+	return s.FinishTurn(currentPlayer)
+}
+
+// EndTurn finishes the current player's turn, or holds it in the discard phase
+// while the player is over the token limit and in the noble phase while more
+// than one noble wants to visit.
+func (s *GameServiceImpl) EndTurn(currentPlayer *gotype.Player) error {
+	if CountPlayerGems(*currentPlayer) > MaxPlayerGems {
+		s.GameState.Phase = gotype.DiscardPhase
+		return nil
+	}
+
+	qualifiedNobles := QualifiedNobles(s.GameState.Nobles, *currentPlayer)
+	if len(qualifiedNobles) > 1 {
+		s.GameState.Phase = gotype.NoblePhase
+		s.GameState.PendingNobles = qualifiedNobles
+		return nil
+	}
+	if len(qualifiedNobles) == 1 {
+		s.AddNobleCard(currentPlayer, qualifiedNobles[0])
+	}
+
+	return s.FinishTurn(currentPlayer)
+}
+
+func (s *GameServiceImpl) FinishTurn(currentPlayer *gotype.Player) error {
 	currentPlayer.Points = CalculatePoints(currentPlayer.PurchaseCards, currentPlayer.NobleCards)
+	s.GameState.Phase = gotype.ActionPhase
+
+	if currentPlayer.Points >= WinningPoints {
+		s.GameState.FinalRound = true
+	}
+
+	if s.IsFinalTurn(currentPlayer.Id) {
+		s.EndGame()
+		return nil
+	}
 
 	if err := s.UpdateNextPlayer(); err != nil {
-		log.Fatal(err)
+		return err
+	}
+	return s.SkipBlockedPlayers()
+}
+
+// IsFinalTurn reports whether the player's turn closes the final round, which
+// ends with the last seat so every player gets the same number of turns.
+func (s *GameServiceImpl) IsFinalTurn(playerId string) bool {
+	lastPlayer := s.GameState.Players[len(s.GameState.Players)-1]
+	return s.GameState.FinalRound && playerId == lastPlayer.Id
+}
+
+func (s *GameServiceImpl) EndGame() {
+	s.GameState.Standings = CalcualteWinner(s.GameState.Players)
+	s.GameState.State = gotype.End
+}
+
+// SkipBlockedPlayers passes the turn of every player who has no legal action,
+// and ends the game once nobody at the table can move.
+func (s *GameServiceImpl) SkipBlockedPlayers() error {
+	for passes := 0; len(LegalActions(s.GameState, s.GameState.CurrentPlayerId)) == 0; passes++ {
+		if passes == len(s.GameState.Players) {
+			s.EndGame()
+			return nil
+		}
+
+		s.events = append(s.events, GameEvent{Type: AutoPassEvent, PlayerId: s.GameState.CurrentPlayerId})
+		if s.IsFinalTurn(s.GameState.CurrentPlayerId) {
+			s.EndGame()
+			return nil
+		}
+
+		if err := s.UpdateNextPlayer(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DrainEvents returns the events raised since the last call and clears them.
+func (s *GameServiceImpl) DrainEvents() []GameEvent {
+	events := s.events
+	s.events = nil
+	return events
+}
+
+func (s *GameServiceImpl) DiscardPlayerGems(currentPlayer *gotype.Player, discardedGems []gotype.GemType) error {
+	overLimit := CountPlayerGems(*currentPlayer) - MaxPlayerGems
+	if len(discardedGems) != overLimit {
+		return NewGameError(CodeInvalidDiscard, "must discard exactly "+strconv.Itoa(overLimit)+" gems")
 	}
 
+	discardCount := make(map[gotype.GemType]int)
+	for _, gem := range discardedGems {
+		discardCount[gem] += 1
+		if discardCount[gem] > currentPlayer.Gems[gem] {
+			return NewGameError(CodeInvalidDiscard, "not enough "+string(gem)+" to discard")
+		}
+	}
+
+	for gem, count := range discardCount {
+		currentPlayer.Gems[gem] -= count
+		s.GameState.Gems[gem] += count
+	}
 	return nil
 }
 
-func (s *GameServiceImpl) UpdatePlayerGems(currentPlayer *gotype.Player, selectedGems []gotype.GemType) {
+func CountPlayerGems(player gotype.Player) int {
+	total := 0
+	for _, count := range player.Gems {
+		total += count
+	}
+	return total
+}
+
+func (s *GameServiceImpl) UpdatePlayerGems(currentPlayer *gotype.Player, selectedGems []gotype.GemType) error {
+	if err := ValidateSelectedGems(s.GameState.Gems, selectedGems); err != nil {
+		return err
+	}
+
 	for _, gems := range selectedGems {
 		currentPlayer.Gems[gems] += 1
 		s.GameState.Gems[gems] -= 1
 	}
+	return nil
 }
 
-func (s *GameServiceImpl) UpdatedPlayerPurchasedCard(currentPlayer *gotype.Player, card gotype.DevelopmentCard) {
-	cardCost := CalculatePayCostReducePurchaseCard(*currentPlayer, card)
+// ValidateSelectedGems checks a take-gems move against the bank: three distinct
+// colors (fewer only when fewer colors remain), or two of one color when the
+// bank holds at least four of it. Jokers can never be taken directly.
+func ValidateSelectedGems(bank map[gotype.GemType]int, selectedGems []gotype.GemType) error {
+	if len(selectedGems) == 0 {
+		return NewGameError(CodeInvalidGemSelection, "no gems selected")
+	}
 
-	for gemType := range currentPlayer.Gems {
-		if cardCost[gemType] > 0 {
-			if currentPlayer.Gems[gemType] >= cardCost[gemType] {
-				// Have token enough for paying then return gems to game state
-				s.GameState.Gems[gemType] += cardCost[gemType]
-				currentPlayer.Gems[gemType] -= cardCost[gemType]
-			} else {
-				// Not enough token pay existing gems + joker gems
-				payWithJoker := currentPlayer.Gems[gemType] - cardCost[gemType]
-				s.GameState.Gems[gemType] += currentPlayer.Gems[gemType]
-				currentPlayer.Gems[gemType] -= currentPlayer.Gems[gemType]
-				currentPlayer.Gems[gotype.Joker] += payWithJoker
-			}
+	for _, gem := range selectedGems {
+		if gem == gotype.Joker {
+			return NewGameError(CodeInvalidGemSelection, "joker cannot be taken directly")
+		}
+		if !slices.Contains(GemColors, gem) {
+			return NewGameError(CodeInvalidGemSelection, "unknown gem type: "+string(gem))
 		}
 	}
+
+	if len(selectedGems) == 2 && selectedGems[0] == selectedGems[1] {
+		if bank[selectedGems[0]] < 4 {
+			return NewGameError(CodeInvalidGemSelection, "taking two "+string(selectedGems[0])+" requires at least four in the bank")
+		}
+		return nil
+	}
+
+	if len(selectedGems) > 3 {
+		return NewGameError(CodeInvalidGemSelection, "cannot take more than three gems")
+	}
+
+	for index, gem := range selectedGems {
+		if slices.Contains(selectedGems[:index], gem) {
+			return NewGameError(CodeInvalidGemSelection, "selected gems must be different colors")
+		}
+		if bank[gem] <= 0 {
+			return NewGameError(CodeInvalidGemSelection, "no "+string(gem)+" left in the bank")
+		}
+	}
+
+	if len(selectedGems) < 3 && len(selectedGems) < AvailableGemColors(bank) {
+		return NewGameError(CodeInvalidGemSelection, "must take three different colors when available")
+	}
+	return nil
+}
+
+func AvailableGemColors(bank map[gotype.GemType]int) int {
+	available := 0
+	for _, gem := range GemColors {
+		if bank[gem] > 0 {
+			available++
+		}
+	}
+	return available
+}
+
+func (s *GameServiceImpl) UpdatedPlayerPurchasedCard(currentPlayer *gotype.Player, cardId int, level int) error {
+	// Card ids repeat across levels, a reserved card only matches its own level
 	isReservedCard := slices.IndexFunc(currentPlayer.ReservedCards, func(rCard gotype.DevelopmentCard) bool {
-		return rCard.ID == card.ID
+		return rCard.ID == cardId && rCard.Level == level
 	})
 
+	// Always charge the server's copy of the card, never the cost sent by the client
+	var purchasedCard gotype.DevelopmentCard
+	var developmentLevel *gotype.DevelopmentLevel
 	if isReservedCard != -1 {
-		if fCard, err := FilterCard(currentPlayer.ReservedCards, card.ID); err == nil {
-			currentPlayer.ReservedCards = fCard
-			currentPlayer.PurchaseCards = append(currentPlayer.PurchaseCards, card)
-		}
+		purchasedCard = currentPlayer.ReservedCards[isReservedCard]
 	} else {
-		switch card.Level {
-		case 1:
-			if fCard, err := FilterCard(s.GameState.DevelopmentTiles.Level1, card.ID); err == nil {
-				s.GameState.DevelopmentTiles.Level1 = fCard
-				currentPlayer.PurchaseCards = append(currentPlayer.PurchaseCards, card)
-			} else {
-				log.Fatal(err)
-			}
-		case 2:
-			if fCard, err := FilterCard(s.GameState.DevelopmentTiles.Level2, card.ID); err == nil {
-				s.GameState.DevelopmentTiles.Level2 = fCard
-				currentPlayer.PurchaseCards = append(currentPlayer.PurchaseCards, card)
-			} else {
-				log.Fatal(err)
-			}
-		case 3:
-			if fCard, err := FilterCard(s.GameState.DevelopmentTiles.Level3, card.ID); err == nil {
-				s.GameState.DevelopmentTiles.Level3 = fCard
-				currentPlayer.PurchaseCards = append(currentPlayer.PurchaseCards, card)
-			} else {
-				log.Fatal(err)
-			}
+		marketLevel, err := s.FindMarketCard(cardId, level)
+		if err != nil {
+			return err
+		}
+		marketIndex := slices.IndexFunc(marketLevel.Market, func(c gotype.DevelopmentCard) bool { return c.ID == cardId })
+		purchasedCard = marketLevel.Market[marketIndex]
+		developmentLevel = marketLevel
+	}
+	purchasedCard.Blind = false
+
+	payment, err := CalculatePaymentPlan(*currentPlayer, purchasedCard)
+	if err != nil {
+		return err
+	}
+
+	if developmentLevel != nil {
+		if _, err := TakeMarketCard(developmentLevel, purchasedCard.ID); err != nil {
+			return err
 		}
+	} else {
+		currentPlayer.ReservedCards = slices.Delete(slices.Clone(currentPlayer.ReservedCards), isReservedCard, isReservedCard+1)
+	}
+
+	for gemType, count := range payment {
+		currentPlayer.Gems[gemType] -= count
+		s.GameState.Gems[gemType] += count
 	}
+	currentPlayer.PurchaseCards = append(currentPlayer.PurchaseCards, purchasedCard)
+	return nil
+}
+
+// CalculatePaymentPlan returns the tokens spent on a card: colored tokens for the
+// cost left after purchased-card discounts, then jokers to cover any shortfall.
+func CalculatePaymentPlan(currentPlayer gotype.Player, card gotype.DevelopmentCard) (map[gotype.GemType]int, error) {
+	cardCost := CalculatePayCostReducePurchaseCard(currentPlayer, card)
+	payment := make(map[gotype.GemType]int)
+
+	jokerCost := 0
+	for _, gemType := range GemColors {
+		payment[gemType] = min(cardCost[gemType], currentPlayer.Gems[gemType])
+		jokerCost += cardCost[gemType] - payment[gemType]
+	}
+
+	if jokerCost > currentPlayer.Gems[gotype.Joker] {
+		return nil, NewGameError(CodeCannotAfford, "not enough gems to purchase card: "+strconv.Itoa(card.ID))
+	}
+	payment[gotype.Joker] = jokerCost
+
+	return payment, nil
+}
+
+func (s *GameServiceImpl) GetDevelopmentLevel(level int) (*gotype.DevelopmentLevel, error) {
+	switch level {
+	case 1:
+		return &s.GameState.DevelopmentTiles.Level1, nil
+	case 2:
+		return &s.GameState.DevelopmentTiles.Level2, nil
+	case 3:
+		return &s.GameState.DevelopmentTiles.Level3, nil
+	}
+	return nil, NewGameError(CodeCardNotFound, "invalid card level: "+strconv.Itoa(level))
+}
+
+// FindMarketCard returns the level whose market shows the card. Card ids
+// repeat across levels, so the card is only looked up in its own level.
+func (s *GameServiceImpl) FindMarketCard(cardId int, level int) (*gotype.DevelopmentLevel, error) {
+	developmentLevel, err := s.GetDevelopmentLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.ContainsFunc(developmentLevel.Market, func(c gotype.DevelopmentCard) bool { return c.ID == cardId }) {
+		return nil, NewGameError(CodeCardNotFound, "card not found: "+strconv.Itoa(cardId)+" in level "+strconv.Itoa(level))
+	}
+	return developmentLevel, nil
+}
+
+// TakeMarketCard removes a face-up card and refills its slot from the draw pile.
+// Once the draw pile is empty the market of that level shrinks instead.
+func TakeMarketCard(level *gotype.DevelopmentLevel, cardId int) (gotype.DevelopmentCard, error) {
+	marketIndex := slices.IndexFunc(level.Market, func(c gotype.DevelopmentCard) bool { return c.ID == cardId })
+	if marketIndex == -1 {
+		return gotype.DevelopmentCard{}, NewGameError(CodeCardNotFound, "card not found: "+strconv.Itoa(cardId))
+	}
+	card := level.Market[marketIndex]
+
+	if deckCard, ok := DrawDeckCard(level); ok {
+		level.Market[marketIndex] = deckCard
+	} else {
+		level.Market = slices.Delete(level.Market, marketIndex, marketIndex+1)
+	}
+	return card, nil
+}
+
+func DrawDeckCard(level *gotype.DevelopmentLevel) (gotype.DevelopmentCard, bool) {
+	if len(level.Deck) == 0 {
+		return gotype.DevelopmentCard{}, false
+	}
+
+	card := level.Deck[0]
+	level.Deck = level.Deck[1:]
+	level.DeckCount = len(level.Deck)
+	return card, true
 }
 
 func CalculatePayCostReducePurchaseCard(currentPlayer gotype.Player, card gotype.DevelopmentCard) map[gotype.GemType]int {
@@ -250,35 +716,65 @@ func CalculatePayCostReducePurchaseCard(currentPlayer gotype.Player, card gotype
 	}
 }
 
-func (s *GameServiceImpl) UpdatedPlayerReservedCard(currentPlayer *gotype.Player, card gotype.DevelopmentCard) {
-	switch card.Level {
-	case 1:
-		if fCard, err := FilterCard(s.GameState.DevelopmentTiles.Level1, card.ID); err == nil {
-			s.GameState.DevelopmentTiles.Level1 = fCard
-			currentPlayer.ReservedCards = append(currentPlayer.ReservedCards, card)
-		} else {
-			log.Fatal(err)
-		}
-	case 2:
-		if fCard, err := FilterCard(s.GameState.DevelopmentTiles.Level2, card.ID); err == nil {
-			s.GameState.DevelopmentTiles.Level2 = fCard
-			currentPlayer.ReservedCards = append(currentPlayer.ReservedCards, card)
-		} else {
-			log.Fatal(err)
-		}
-	case 3:
-		if fCard, err := FilterCard(s.GameState.DevelopmentTiles.Level3, card.ID); err == nil {
-			s.GameState.DevelopmentTiles.Level3 = fCard
-			currentPlayer.ReservedCards = append(currentPlayer.ReservedCards, card)
-		} else {
-			log.Fatal(err)
-		}
+func (s *GameServiceImpl) UpdatedPlayerReservedCard(currentPlayer *gotype.Player, cardId int, level int) error {
+	if err := CheckReserveLimit(*currentPlayer); err != nil {
+		return err
 	}
+
+	developmentLevel, err := s.FindMarketCard(cardId, level)
+	if err != nil {
+		return err
+	}
+
+	reservedCard, err := TakeMarketCard(developmentLevel, cardId)
+	if err != nil {
+		return err
+	}
+
+	s.AddReservedCard(currentPlayer, reservedCard)
+	return nil
 }
 
-func (s *GameServiceImpl) AddNobleCard(currentPlayer *gotype.Player) {
-	var removeNobleIndex = -1
-	for index, noble := range s.GameState.Nobles {
+// ReserveDeckCard reserves the top card of a level's draw pile.
+func (s *GameServiceImpl) ReserveDeckCard(currentPlayer *gotype.Player, level int) error {
+	if err := CheckReserveLimit(*currentPlayer); err != nil {
+		return err
+	}
+
+	developmentLevel, err := s.GetDevelopmentLevel(level)
+	if err != nil {
+		return err
+	}
+
+	deckCard, ok := DrawDeckCard(developmentLevel)
+	if !ok {
+		return NewGameError(CodeDeckEmpty, "no face-down cards left in level "+strconv.Itoa(level))
+	}
+
+	deckCard.Blind = true
+	s.AddReservedCard(currentPlayer, deckCard)
+	return nil
+}
+
+func CheckReserveLimit(currentPlayer gotype.Player) error {
+	if len(currentPlayer.ReservedCards) >= MaxReservedCards {
+		return NewGameError(CodeReserveLimit, "cannot reserve more than "+strconv.Itoa(MaxReservedCards)+" cards")
+	}
+	return nil
+}
+
+func (s *GameServiceImpl) AddReservedCard(currentPlayer *gotype.Player, card gotype.DevelopmentCard) {
+	currentPlayer.ReservedCards = append(currentPlayer.ReservedCards, card)
+	// Reserving grants a joker only while the bank still has one
+	if s.GameState.Gems[gotype.Joker] > 0 {
+		s.GameState.Gems[gotype.Joker] -= 1
+		currentPlayer.Gems[gotype.Joker] += 1
+	}
+}
+
+func QualifiedNobles(nobles []gotype.NobleCard, currentPlayer gotype.Player) []int {
+	var qualified []int
+	for _, noble := range nobles {
 		diamondPass := CalculateCardGems(currentPlayer.PurchaseCards, gotype.Diamond) >= noble.Cost[gotype.Diamond]
 		saphirePass := CalculateCardGems(currentPlayer.PurchaseCards, gotype.Sapphire) >= noble.Cost[gotype.Sapphire]
 		emeraldPass := CalculateCardGems(currentPlayer.PurchaseCards, gotype.Emerald) >= noble.Cost[gotype.Emerald]
@@ -286,25 +782,82 @@ func (s *GameServiceImpl) AddNobleCard(currentPlayer *gotype.Player) {
 		onyxPass := CalculateCardGems(currentPlayer.PurchaseCards, gotype.Onyx) >= noble.Cost[gotype.Onyx]
 
 		if diamondPass && saphirePass && emeraldPass && rubyPass && onyxPass {
-			currentPlayer.NobleCards = append(currentPlayer.NobleCards, noble)
-			removeNobleIndex = index
-			currentPlayer.Points = currentPlayer.Points + noble.Points
-			break
+			qualified = append(qualified, noble.ID)
 		}
 	}
+	return qualified
+}
 
-	if removeNobleIndex != -1 {
-		s.GameState.Nobles = append(s.GameState.Nobles[:removeNobleIndex], s.GameState.Nobles[removeNobleIndex+1:]...)
+func (s *GameServiceImpl) AddNobleCard(currentPlayer *gotype.Player, nobleId int) {
+	removeNobleIndex := slices.IndexFunc(s.GameState.Nobles, func(noble gotype.NobleCard) bool { return noble.ID == nobleId })
+	if removeNobleIndex == -1 {
+		return
 	}
+
+	currentPlayer.NobleCards = append(currentPlayer.NobleCards, s.GameState.Nobles[removeNobleIndex])
+	s.GameState.Nobles = append(s.GameState.Nobles[:removeNobleIndex], s.GameState.Nobles[removeNobleIndex+1:]...)
+}
+
+func (s *GameServiceImpl) GrantPendingNoble(currentPlayer *gotype.Player, nobleId int) error {
+	if !slices.Contains(s.GameState.PendingNobles, nobleId) {
+		return NewGameError(CodeInvalidNoble, "noble cannot be chosen: "+strconv.Itoa(nobleId))
+	}
+
+	s.AddNobleCard(currentPlayer, nobleId)
+	s.GameState.PendingNobles = nil
+	return nil
+}
+
+// AutoChooseNoble picks the first pending noble once the choice timeout for
+// the given turn has passed.
+func (s *GameServiceImpl) AutoChooseNoble(turn int) error {
+	if s.GameState.Phase != gotype.NoblePhase || s.GameState.Turn != turn {
+		return NewGameError(CodeInvalidNoble, "no noble choice pending for turn "+strconv.Itoa(turn))
+	}
+
+	if s.GameState.Paused {
+		return NewGameError(CodeGamePaused, "game is paused until every player reconnects")
+	}
+
+	playerIndex := slices.IndexFunc(s.GameState.Players, func(p gotype.Player) bool { return p.Id == s.GameState.CurrentPlayerId })
+	if playerIndex == -1 {
+		return errors.New("not found player: " + s.GameState.CurrentPlayerId)
+	}
+	currentPlayer := &s.GameState.Players[playerIndex]
+
+	if err := s.GrantPendingNoble(currentPlayer, s.GameState.PendingNobles[0]); err != nil {
+		return err
+	}
+	return s.FinishTurn(currentPlayer)
 }
 
-func FilterCard(card []gotype.DevelopmentCard, removeId int) ([]gotype.DevelopmentCard, error) {
-	removeIndex := slices.IndexFunc(card, func(c gotype.DevelopmentCard) bool { return c.ID == removeId })
+// PlayerLimits returns the room's minimum and maximum players. Unset limits
+// fall back to the base game's and every limit is kept within it.
+func PlayerLimits(settings gotype.GameSettings) (int, int) {
+	minPlayers := MinPlayers
+	if settings.MinPlayers > 0 {
+		minPlayers = min(max(settings.MinPlayers, MinPlayers), MaxPlayers)
+	}
 
-	if removeIndex == -1 {
-		return nil, errors.New("filter card not found removeId" + strconv.Itoa(int(removeId)))
+	maxPlayers := MaxPlayers
+	if settings.MaxPlayers > 0 {
+		maxPlayers = min(max(settings.MaxPlayers, minPlayers), MaxPlayers)
 	}
-	return append(card[:removeIndex], card[removeIndex+1:]...), nil
+	return minPlayers, maxPlayers
+}
+
+func ReconnectGrace(settings gotype.GameSettings) time.Duration {
+	if settings.ReconnectSeconds <= 0 {
+		return DefaultReconnectSeconds * time.Second
+	}
+	return time.Duration(settings.ReconnectSeconds) * time.Second
+}
+
+func NobleChoiceTimeout(settings gotype.GameSettings) time.Duration {
+	if settings.NobleChoiceSeconds <= 0 {
+		return DefaultNobleChoiceSeconds * time.Second
+	}
+	return time.Duration(settings.NobleChoiceSeconds) * time.Second
 }
 
 func CalculatePoints(purchasedCards []gotype.DevelopmentCard, noble []gotype.NobleCard) int {
@@ -348,7 +901,39 @@ func (s *GameServiceImpl) UpdateNextPlayer() error {
 		nextIndex = 0
 	}
 	s.GameState.CurrentPlayerId = s.GameState.Players[nextIndex].Id
+	s.GameState.Turn++
 	return nil
 }
 
-func CalcualteWinner() {}
+// CalcualteWinner ranks players by points, breaking ties with the fewest
+// purchased development cards. Players still tied share the rank.
+func CalcualteWinner(players []gotype.Player) []gotype.Standing {
+	standings := make([]gotype.Standing, 0, len(players))
+	for _, player := range players {
+		standings = append(standings, gotype.Standing{
+			PlayerId:       player.Id,
+			Points:         player.Points,
+			PurchasedCards: len(player.PurchaseCards),
+		})
+	}
+
+	slices.SortStableFunc(standings, func(a, b gotype.Standing) int {
+		if a.Points != b.Points {
+			return b.Points - a.Points
+		}
+		return a.PurchasedCards - b.PurchasedCards
+	})
+
+	for index := range standings {
+		standings[index].Rank = index + 1
+		if index > 0 {
+			previous := standings[index-1]
+			if previous.Points == standings[index].Points && previous.PurchasedCards == standings[index].PurchasedCards {
+				standings[index].Rank = previous.Rank
+			}
+		}
+		standings[index].Winner = standings[index].Rank == 1
+	}
+
+	return standings
+}
@@ -0,0 +1,60 @@
+package core
+
+import "sync"
+
+// RoomRegistry owns the open rooms by id. HTTP handlers, websocket handlers and
+// room loops all share it, so every access goes through its lock.
+type RoomRegistry struct {
+	mu    sync.RWMutex
+	rooms map[string]*Room
+}
+
+func NewRoomRegistry() *RoomRegistry {
+	return &RoomRegistry{rooms: make(map[string]*Room)}
+}
+
+func (rr *RoomRegistry) Get(roomID string) (*Room, bool) {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	room, exists := rr.rooms[roomID]
+	return room, exists
+}
+
+// GetOrCreate returns the room registered under roomID, or registers the one
+// built by newRoom. The bool reports whether the room was created, so exactly
+// one caller starts it even when many players join at once.
+func (rr *RoomRegistry) GetOrCreate(roomID string, newRoom func() *Room) (*Room, bool) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	if room, exists := rr.rooms[roomID]; exists {
+		return room, false
+	}
+	room := newRoom()
+	rr.rooms[roomID] = room
+	return room, true
+}
+
+// Remove unregisters the room only if it is still the one registered under
+// roomID, so a closing room never removes a newer room with the same id.
+func (rr *RoomRegistry) Remove(roomID string, room *Room) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	if rr.rooms[roomID] == room {
+		delete(rr.rooms, roomID)
+	}
+}
+
+// Snapshot copies the registry so callers can range over it without holding the lock.
+func (rr *RoomRegistry) Snapshot() map[string]*Room {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	rooms := make(map[string]*Room, len(rr.rooms))
+	for roomID, room := range rr.rooms {
+		rooms[roomID] = room
+	}
+	return rooms
+}
@@ -0,0 +1,227 @@
+package core
+
+import (
+	"net"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/nuttaponsrpn/go-splendor/gotype"
+)
+
+// newTestServer serves JoinRoom for the rooms on a local port and returns the
+// websocket URL. Every room still open when the test ends is closed.
+func newTestServer(t *testing.T, rooms *RoomRegistry) (GameRoom, string) {
+	t.Helper()
+
+	gs := NewGameRoomService(rooms)
+	t.Cleanup(func() { closeRooms(gs, rooms) })
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/ws", websocket.New(func(conn *websocket.Conn) {
+		gs.JoinRoom(conn.Query("room_id"), conn.Query("player_id"), conn.Query("session"), gotype.GameSettings{}, conn)
+	}))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(listener)
+	t.Cleanup(func() { app.Shutdown() })
+
+	return gs, "ws://" + listener.Addr().String() + "/ws"
+}
+
+// closeRooms closes every registered room and waits for its run loop to stop.
+func closeRooms(gs GameRoom, rooms *RoomRegistry) {
+	for roomID, room := range rooms.Snapshot() {
+		gs.CloseRoom(roomID)
+		<-room.closed
+	}
+}
+
+func dialRoom(serverURL string, roomID string, playerID string) (*fastws.Conn, error) {
+	query := url.Values{"room_id": {roomID}, "player_id": {playerID}}
+	conn, _, err := fastws.DefaultDialer.Dial(serverURL+"?"+query.Encode(), nil)
+	return conn, err
+}
+
+// countRunLoops counts the goroutines running a room loop.
+func countRunLoops() int {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return strings.Count(string(buf[:n]), "core.(*Room).run(")
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for " + what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRoomRegistryConcurrentAccess(t *testing.T) {
+	rooms := NewRoomRegistry()
+	roomIDs := []string{"a", "b", "c", "d"}
+
+	var mu sync.Mutex
+	built := make(map[string]int)
+	registered := make(map[string]*Room)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		roomID := roomIDs[i%len(roomIDs)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			room, _ := rooms.GetOrCreate(roomID, func() *Room {
+				mu.Lock()
+				built[roomID]++
+				mu.Unlock()
+				return &Room{id: roomID}
+			})
+			if got, exists := rooms.Get(roomID); !exists || got != room {
+				t.Errorf("room %s is not the registered room", roomID)
+			}
+			rooms.Snapshot()
+
+			mu.Lock()
+			if registered[roomID] == nil {
+				registered[roomID] = room
+			} else if registered[roomID] != room {
+				t.Errorf("room %s was created twice", roomID)
+			}
+			mu.Unlock()
+
+			// Removing a stale room must never unregister the live one
+			rooms.Remove(roomID, &Room{id: roomID})
+		}()
+	}
+	wg.Wait()
+
+	for _, roomID := range roomIDs {
+		if built[roomID] != 1 {
+			t.Errorf("room %s was built %d times, want 1", roomID, built[roomID])
+		}
+	}
+	if snapshot := rooms.Snapshot(); len(snapshot) != len(roomIDs) {
+		t.Errorf("registry holds %d rooms, want %d", len(snapshot), len(roomIDs))
+	}
+
+	for _, roomID := range roomIDs {
+		rooms.Remove(roomID, registered[roomID])
+		if _, exists := rooms.Get(roomID); exists {
+			t.Errorf("room %s is still registered after Remove", roomID)
+		}
+	}
+}
+
+func TestCreateRoomStartsOneRunLoopPerRoom(t *testing.T) {
+	gs := NewGameRoomService(NewRoomRegistry())
+	runLoops := countRunLoops()
+
+	var mu sync.Mutex
+	created := make(map[string]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		roomID := "room-" + strconv.Itoa(i%5)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, ok := gs.CreateRoom(roomID, "host", gotype.GameSettings{}); ok {
+				mu.Lock()
+				created[roomID]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(created) != 5 {
+		t.Fatalf("created %d rooms, want 5", len(created))
+	}
+	for roomID, count := range created {
+		if count != 1 {
+			t.Errorf("room %s was created %d times, want 1", roomID, count)
+		}
+	}
+	waitFor(t, "run loops to start", func() bool { return countRunLoops()-runLoops >= 5 })
+	if got := countRunLoops() - runLoops; got != 5 {
+		t.Errorf("%d run loops started, want 5", got)
+	}
+
+	for roomID := range created {
+		gs.CloseRoom(roomID)
+	}
+	waitFor(t, "run loops to stop", func() bool { return countRunLoops() == runLoops })
+}
+
+func TestJoinRoomConcurrentJoiners(t *testing.T) {
+	rooms := NewRoomRegistry()
+	_, serverURL := newTestServer(t, rooms)
+	runLoops := countRunLoops()
+
+	var mu sync.Mutex
+	var conns []*fastws.Conn
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		roomID := "room-" + strconv.Itoa(i%4)
+		playerID := "player-" + strconv.Itoa(i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			conn, err := dialRoom(serverURL, roomID, playerID)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	connected := func() int {
+		clients := 0
+		for _, room := range rooms.Snapshot() {
+			room.Do(func() { clients += len(room.clients) })
+		}
+		return clients
+	}
+	waitFor(t, "every joiner to connect", func() bool { return connected() == 40 })
+
+	if snapshot := rooms.Snapshot(); len(snapshot) != 4 {
+		t.Errorf("registry holds %d rooms, want 4", len(snapshot))
+	}
+	waitFor(t, "run loops to start", func() bool { return countRunLoops()-runLoops >= 4 })
+	if got := countRunLoops() - runLoops; got != 4 {
+		t.Errorf("%d run loops started, want 4", got)
+	}
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+	waitFor(t, "empty rooms to close", func() bool { return len(rooms.Snapshot()) == 0 })
+	waitFor(t, "run loops to stop", func() bool { return countRunLoops() == runLoops })
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"errors"
+
+	"github.com/nuttaponsrpn/go-splendor/gotype"
+)
+
+type ServerMessageType string
+
+const (
+	StateMessage ServerMessageType = "state"
+	ErrorMessage ServerMessageType = "error"
+	EventMessage ServerMessageType = "event"
+	// SessionMessage hands a seated player the token to reconnect with
+	SessionMessage ServerMessageType = "session"
+)
+
+type GameEventType string
+
+const (
+	AutoPassEvent   GameEventType = "auto_pass"
+	PlayerLeftEvent GameEventType = "player_left"
+)
+
+// GameEvent tells every client about something the server did on its own,
+// such as passing the turn of a player who cannot move.
+type GameEvent struct {
+	Type     GameEventType `json:"type"`
+	PlayerId string        `json:"playerId"`
+}
+
+// ServerMessage is the envelope of every message a room sends to its clients.
+type ServerMessage struct {
+	Type         ServerMessageType `json:"type"`
+	State        *gotype.GameState `json:"state,omitempty"`
+	LegalActions []LegalAction     `json:"legalActions,omitempty"`
+	Event        *GameEvent        `json:"event,omitempty"`
+	Error        *GameError        `json:"error,omitempty"`
+	ActionId     string            `json:"actionId,omitempty"`
+	Session      string            `json:"session,omitempty"`
+}
+
+func NewErrorMessage(err error, actionId string) ServerMessage {
+	return ServerMessage{Type: ErrorMessage, Error: ToGameError(err), ActionId: actionId}
+}
+
+func ToGameError(err error) *GameError {
+	var gameError *GameError
+	if errors.As(err, &gameError) {
+		return gameError
+	}
+	return NewGameError(CodeInternal, err.Error())
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+
+	"github.com/nuttaponsrpn/go-splendor/gotype"
+)
+
+// Session holds a player's seat in a room. The token lets the player take the
+// seat back from a new connection, and while no connection holds it the grace
+// timer runs out towards removing the player.
+type Session struct {
+	playerID string
+	token    string
+	client   *Client
+	timer    RoomTimer
+}
+
+func NewSessionToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+func (r *Room) holdsSeat(client *Client) bool {
+	session, ok := r.sessions[client.playerID]
+	return ok && session.client == client
+}
+
+// seat binds the client to its player's session, opening one on first join,
+// and sends it the token.
+func (r *Room) seat(client *Client) error {
+	session, ok := r.sessions[client.playerID]
+	if !ok {
+		token, err := NewSessionToken()
+		if err != nil {
+			return err
+		}
+		session = &Session{playerID: client.playerID, token: token}
+		r.sessions[client.playerID] = session
+	}
+
+	session.client = client
+	r.sendTo(client, ServerMessage{Type: SessionMessage, Session: session.token})
+	return nil
+}
+
+// resume hands the seat of a session to a reconnecting client. An older
+// connection still holding the seat is dropped.
+func (r *Room) resume(client *Client, token string) bool {
+	session, ok := r.sessions[client.playerID]
+	if r.state == RoomClosed || !ok || session.token != token {
+		return false
+	}
+
+	if session.client != nil {
+		delete(r.clients, session.client)
+		session.client.disconnect()
+	}
+	if session.timer != nil {
+		session.timer.Stop()
+		session.timer = nil
+	}
+
+	session.client = client
+	r.clients[client] = client.playerID
+	client.Room = r
+	r.updatePaused()
+	return true
+}
+
+// disconnect keeps the seat open for the grace period and pauses the game
+// until the player is back.
+func (r *Room) disconnect(session *Session) {
+	session.client = nil
+
+	var timer RoomTimer
+	timer = r.afterFunc(ReconnectGrace(r.GameService.GetGameState().Settings), func() {
+		r.Do(func() {
+			// A reconnect, or a later disconnect, replaces the timer
+			if session.timer == timer {
+				r.expire(session)
+			}
+		})
+	})
+	session.timer = timer
+	r.updatePaused()
+}
+
+func (r *Room) expire(session *Session) {
+	delete(r.sessions, session.playerID)
+	if err := r.GameService.RemovePlayer(session.playerID); err != nil {
+		log.Printf("error: %v", err)
+	}
+	r.updatePaused()
+	r.announceEvents()
+	r.broadcastState()
+	r.closeIfAbandoned()
+}
+
+// updatePaused pauses the game while any seat is waiting for its player. The
+// noble timer stops with the pause or once no noble choice is pending, and a
+// noble choice left pending by the pause gets a fresh timeout on resume.
+func (r *Room) updatePaused() {
+	paused := false
+	for _, session := range r.sessions {
+		if session.client == nil {
+			paused = true
+		}
+	}
+
+	wasPaused := r.GameService.GetGameState().Paused
+	r.GameService.SetPaused(paused)
+
+	gameState := r.GameService.GetGameState()
+	switch {
+	case gameState.Paused || gameState.Phase != gotype.NoblePhase:
+		r.stopNobleAutoPick()
+	case wasPaused:
+		r.scheduleNobleAutoPick()
+	}
+}
@@ -2,12 +2,14 @@ module github.com/nuttaponsrpn/go-splendor
 
 go 1.22.1
 
-require github.com/gofiber/fiber/v2 v2.52.4
+require (
+	github.com/fasthttp/websocket v1.5.3
+	github.com/gofiber/fiber/v2 v2.52.4
+	github.com/gofiber/websocket/v2 v2.2.1
+)
 
 require (
 	github.com/andybalholm/brotli v1.0.5 // indirect
-	github.com/fasthttp/websocket v1.5.3 // indirect
-	github.com/gofiber/websocket/v2 v2.2.1 // indirect
 	github.com/google/uuid v1.5.0 // indirect
 	github.com/klauspost/compress v1.17.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
@@ -18,6 +20,5 @@ require (
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasthttp v1.51.0 // indirect
 	github.com/valyala/tcplisten v1.0.0 // indirect
-	golang.org/x/net v0.23.0 // indirect
 	golang.org/x/sys v0.18.0 // indirect
 )
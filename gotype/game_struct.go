@@ -7,6 +7,18 @@ type GameState struct {
 	Nobles           []NobleCard      `json:"nobles"`
 	DevelopmentTiles DevelopmentTiles `json:"developmentTiles"`
 	State            Status           `json:"state"`
+	Phase            TurnPhase        `json:"phase"`
+	FinalRound       bool             `json:"finalRound"`
+	Standings        []Standing       `json:"standings"`
+	Settings         GameSettings     `json:"settings"`
+	Turn             int              `json:"turn"`
+	PendingNobles    []int            `json:"pendingNobles"`
+	// HostId is the seated player who may start the game, the creator while they hold a seat
+	HostId string `json:"hostId"`
+	// CreatorId is the player who created the room
+	CreatorId string `json:"creatorId"`
+	// Paused holds a running game while a seated player is reconnecting
+	Paused bool `json:"paused"`
 }
 
 type Player struct {
@@ -16,6 +28,36 @@ type Player struct {
 	ReservedCards []DevelopmentCard `json:"reservedCards"`
 	PurchaseCards []DevelopmentCard `json:"purchasedCards"`
 	NobleCards    []NobleCard       `json:"nobleCards"`
+	Ready         bool              `json:"ready"`
+}
+
+type GameSettings struct {
+	Variant SetupVariant `json:"variant"`
+	// NobleChoiceSeconds is how long a player may take to pick a noble before one is picked for them
+	NobleChoiceSeconds int `json:"nobleChoiceSeconds"`
+	// Seed drives every shuffle of the game so it can be replayed exactly
+	Seed int64 `json:"seed"`
+	// MinPlayers and MaxPlayers bound the room, zero means the 2 to 4 players of the base game
+	MinPlayers int `json:"minPlayers"`
+	MaxPlayers int `json:"maxPlayers"`
+	// ReconnectSeconds is how long a disconnected player keeps their seat
+	ReconnectSeconds int `json:"reconnectSeconds"`
+}
+
+type SetupVariant string
+
+const (
+	StandardSetup SetupVariant = "standard"
+	// FullSetup is a house rule that always uses the four-player tokens and nobles
+	FullSetup SetupVariant = "full"
+)
+
+type Standing struct {
+	PlayerId       string `json:"playerId"`
+	Points         int    `json:"points"`
+	PurchasedCards int    `json:"purchasedCards"`
+	Rank           int    `json:"rank"`
+	Winner         bool   `json:"winner"`
 }
 
 type Gems struct {
@@ -39,9 +81,15 @@ const (
 )
 
 type DevelopmentTiles struct {
-	Level1 []DevelopmentCard `json:"level1"`
-	Level2 []DevelopmentCard `json:"level2"`
-	Level3 []DevelopmentCard `json:"level3"`
+	Level1 DevelopmentLevel `json:"level1"`
+	Level2 DevelopmentLevel `json:"level2"`
+	Level3 DevelopmentLevel `json:"level3"`
+}
+
+type DevelopmentLevel struct {
+	Market    []DevelopmentCard `json:"market"`
+	Deck      []DevelopmentCard `json:"deck,omitempty"`
+	DeckCount int               `json:"deckCount"`
 }
 
 type DevelopmentCard struct {
@@ -50,6 +98,7 @@ type DevelopmentCard struct {
 	Cost    Gems    `json:"cost"`
 	Points  int     `json:"points"`
 	GemType GemType `json:"gemType"`
+	Blind   bool    `json:"blind"`
 }
 
 type NobleCard struct {
@@ -61,8 +110,15 @@ type NobleCard struct {
 type Status string
 
 const (
-	Waiting         Status = "Waiting"
-	Started         Status = "Started"
-	End             Status = "End"
-	CloseConnection Status = "CloseConnection"
+	Waiting Status = "Waiting"
+	Started Status = "Started"
+	End     Status = "End"
+)
+
+type TurnPhase string
+
+const (
+	ActionPhase  TurnPhase = "Action"
+	DiscardPhase TurnPhase = "Discard"
+	NoblePhase   TurnPhase = "ChooseNoble"
 )
@@ -5,11 +5,12 @@ import (
 	"github.com/gofiber/websocket/v2"
 	"github.com/nuttaponsrpn/go-splendor/adapters"
 	"github.com/nuttaponsrpn/go-splendor/core"
+	"github.com/nuttaponsrpn/go-splendor/gotype"
 )
 
 func main() {
-	var rooms = make(map[string]*core.Room)
-	gameRoomService := core.NewGameRoomService(&rooms)
+	rooms := core.NewRoomRegistry()
+	gameRoomService := core.NewGameRoomService(rooms)
 	gameRoomAdapter := adapters.NewGameRoomAdapter(&gameRoomService)
 
 	app := fiber.New()
@@ -21,13 +22,33 @@ func main() {
 
 	// HTTP GET all rooms
 	app.Get("/rooms", func(c *fiber.Ctx) error {
-		return c.Status(fiber.StatusOK).JSON(rooms)
+		return c.Status(fiber.StatusOK).JSON(rooms.Snapshot())
 	})
 
-	// HTTP GET route
+	// HTTP POST create a room before anyone joins it, e.g. to replay a seed.
+	// Rooms nobody joins close after core.RoomIdleTimeout
+	app.Post("/rooms", func(c *fiber.Ctx) error {
+		var body struct {
+			RoomID   string              `json:"roomId"`
+			PlayerID string              `json:"playerId"`
+			Settings gotype.GameSettings `json:"settings"`
+		}
+		if err := c.BodyParser(&body); err != nil || body.RoomID == "" || body.PlayerID == "" {
+			return fiber.ErrBadRequest
+		}
+
+		// The player creating the room hosts it once they join
+		room, created := gameRoomService.CreateRoom(body.RoomID, body.PlayerID, body.Settings)
+		if !created {
+			return c.Status(fiber.StatusConflict).JSON(room)
+		}
+		return c.Status(fiber.StatusCreated).JSON(room)
+	})
+
+	// HTTP DELETE close a room and disconnect its players
 	app.Delete("/rooms", func(c *fiber.Ctx) error {
 		m := c.Queries()
-		room := gameRoomService.DeleteRoom(m["room_id"])
+		room := gameRoomService.CloseRoom(m["room_id"])
 		return c.Status(fiber.StatusOK).JSON(room)
 	})
 